@@ -0,0 +1,60 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// golinkinterceptor bundles the interceptor and the executor as subcommands of
+// a single binary.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+	"github.com/L3n41c/golinkinterceptor/internal/execute"
+	"github.com/L3n41c/golinkinterceptor/internal/intercept"
+)
+
+func main() {
+	var opts cli.Options
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	opts.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] <command> [command flags] [arguments]\n", fs.Name())
+		fmt.Fprintln(fs.Output(), "\nCommands:")
+		fmt.Fprintln(fs.Output(), "  intercept  Store the link commands of a Go build")
+		fmt.Fprintln(fs.Output(), "  exec       Relink a binary from its stored link command and execute it")
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[1:])
+
+	// A hung build or linker can be interrupted, in which case temporary files
+	// are removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if fs.NArg() < 1 {
+		cli.Exit(&cli.UsageError{Msg: "Need a command"}, fs)
+	}
+
+	commandFS := flag.NewFlagSet(fs.Name()+" "+fs.Arg(0), flag.ExitOnError)
+	var err error
+	switch fs.Arg(0) {
+	case "intercept":
+		err = intercept.Run(ctx, commandFS, fs.Args()[1:], &opts)
+	case "exec":
+		err = execute.Run(ctx, stop, commandFS, fs.Args()[1:], &opts)
+	default:
+		err = &cli.UsageError{Msg: fmt.Sprintf("Unknown command %q", fs.Arg(0))}
+		commandFS = fs
+	}
+	if err != nil {
+		cli.Exit(err, commandFS) //nolint:gocritic
+	}
+}
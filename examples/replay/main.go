@@ -0,0 +1,58 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Replay relinks a binary in-process from the `go build -x` output read on
+// stdin, stored in an in-memory database:
+//
+//	go build -x -o app . 2>&1 | replay -o relinked app
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/L3n41c/golinkinterceptor/pkg/executor"
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+func main() {
+	var opts executor.ReplayOptions
+	flag.StringVar(&opts.Output, "o", "", "Write the relinked binary to this path")
+	flag.BoolVar(&opts.Exec, "exec", false, "Execute the relinked binary with the remaining arguments")
+	flag.StringVar(&opts.CodesignIdentity, "codesign-identity", "", "Sign the relinked binary with this identity, - for ad-hoc, like macOS requires")
+	tags := flag.String("tags", "", "Build tags of the binary")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("Need a binary name")
+	}
+	opts.Args = flag.Args()[1:]
+	opts.Stdout = os.Stdout
+	opts.Stderr = os.Stderr
+
+	ctx := context.Background()
+	result, err := interceptor.ParseBuildOutput(ctx, os.Stdin, interceptor.DefaultMaxLineLength)
+	if err != nil {
+		log.Fatalf("Unable to parse the build output: %v", err)
+	}
+	for i, linkCommand := range result.LinkCommands {
+		result.LinkCommands[i].BinaryName = linkCommand.Output
+	}
+
+	db, err := interceptor.OpenDB(ctx, interceptor.MemoryDB, 0)
+	if err != nil {
+		log.Fatalf("Unable to open the database: %v", err)
+	}
+	defer db.Close()
+	if err := interceptor.Store(ctx, db, result, false); err != nil {
+		log.Fatalf("Unable to store the link commands: %v", err)
+	}
+
+	if err := executor.Replay(ctx, db, flag.Arg(0), strings.Split(*tags, ","), opts); err != nil {
+		log.Fatalf("Unable to replay the link command: %v", err) //nolint:gocritic
+	}
+}
@@ -0,0 +1,153 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package cli holds the command line handling shared by the interceptor and
+// the executor.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// DBPathEnvVar is the environment variable setting the path of the sqlite DB
+// when the `-db` flag isn’t given.
+const DBPathEnvVar = "GOLINK_DB"
+
+// Options are the command line options common to the interceptor and the
+// executor.
+type Options struct {
+	DBPath    string
+	LogLevel  uint
+	LogFormat string
+	GoCache   string // Overrides GOCACHE if set
+	Metrics   bool   // Durations of the phases are written to stderr
+}
+
+// AddFlags registers the common options on fs.
+// The path of the DB defaults to the value of DBPathEnvVar.
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	dbPath := "link.db"
+	if envDBPath := os.Getenv(DBPathEnvVar); envDBPath != "" {
+		dbPath = envDBPath
+	}
+	fs.UintVar(&o.LogLevel, "log-level", 0, "Log level (0 = warnings, 1 = info, 2 = debug)")
+	fs.StringVar(&o.LogFormat, "log-format", "text", "Format of the logs written to stderr (text or json)")
+	fs.StringVar(&o.DBPath, "db", dbPath, "Path to the sqlite DB, or :memory: for a DB discarded on exit (defaults to $"+DBPathEnvVar+" if set)")
+	fs.BoolVar(&o.Metrics, "metrics", false, "Write the duration of each phase, like the build, the DB queries or the link, to stderr at the end")
+	fs.StringVar(&o.GoCache, "gocache", "", "Go build cache of the builds and of the package files, for hermetic builds using their own (defaults to `go env GOCACHE`)")
+}
+
+// SetGoCache sets GOCACHE to the Go build cache of the options, so that it is
+// the one of the go commands run and of the Go environment.
+func (o *Options) SetGoCache() error {
+	if o.GoCache == "" {
+		return nil
+	}
+	// The go command only accepts an absolute GOCACHE
+	goCache, err := filepath.Abs(o.GoCache)
+	if err != nil {
+		return fmt.Errorf("unable to get absolute path of the Go build cache: %w", err)
+	}
+	if err := os.Setenv("GOCACHE", goCache); err != nil {
+		return fmt.Errorf("unable to set GOCACHE: %w", err)
+	}
+	return nil
+}
+
+// jsonLogger reports the error ending the program when the logs are in JSON.
+// Otherwise it is printed as plain text, as it often spans several lines.
+var jsonLogger *slog.Logger
+
+// Logger returns the logger writing to stderr in the log format.
+// Log level 0 only keeps the warnings, so that the programs are usable as
+// transparent shims, levels 1 and 2 keep those from the info and debug levels.
+// The error ending the program is reported whatever the log level.
+func (o *Options) Logger() (*slog.Logger, error) {
+	return o.logger(os.Stderr)
+}
+
+func (o *Options) logger(w io.Writer) (*slog.Logger, error) {
+	level := slog.LevelWarn
+	switch {
+	case o.LogLevel >= 2:
+		level = slog.LevelDebug
+	case o.LogLevel == 1:
+		level = slog.LevelInfo
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	switch o.LogFormat {
+	case "text":
+		jsonLogger = nil
+		return slog.New(slog.NewTextHandler(w, handlerOptions)), nil
+	case "json":
+		jsonLogger = slog.New(slog.NewJSONHandler(w, nil))
+		return slog.New(slog.NewJSONHandler(w, handlerOptions)), nil
+	}
+
+	return nil, &UsageError{Msg: fmt.Sprintf("Unknown log format %q, expected text or json", o.LogFormat)}
+}
+
+// UsageError is returned when the command line is invalid.
+type UsageError struct {
+	Msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+// ExitError is returned when the program must exit with a specific status.
+// Err is nil when the cause of the failure was already reported.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Exit reports err and exits with the matching status.
+// The usage of fs is printed for usage errors.
+func Exit(err error, fs *flag.FlagSet) {
+	var usageErr *UsageError
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &usageErr):
+		fmt.Fprintln(os.Stderr, usageErr)
+		fs.Usage()
+		os.Exit(2)
+	case errors.As(err, &exitErr):
+		if exitErr.Err != nil {
+			reportError(exitErr.Err)
+		}
+		os.Exit(exitErr.Code)
+	}
+	reportError(err)
+	os.Exit(1)
+}
+
+func reportError(err error) {
+	if jsonLogger != nil {
+		jsonLogger.Error("Error", "error", err)
+		return
+	}
+	log.Printf("Error: %v", err)
+}
@@ -0,0 +1,116 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		logLevel uint
+		want     []string // Records logged, one per level
+	}{
+		{
+			name:     "warnings",
+			logLevel: 0,
+			want:     []string{"WARN"},
+		},
+		{
+			name:     "info",
+			logLevel: 1,
+			want:     []string{"WARN", "INFO"},
+		},
+		{
+			name:     "debug",
+			logLevel: 2,
+			want:     []string{"WARN", "INFO", "DEBUG"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("text", func(t *testing.T) {
+				var buf bytes.Buffer
+				logger, err := (&Options{LogLevel: tt.logLevel, LogFormat: "text"}).logger(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				logRecords(logger.Warn, logger.Info, logger.Debug)
+
+				var lines []string
+				if buf.Len() > 0 {
+					lines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+				}
+				if len(lines) != len(tt.want) {
+					t.Fatalf("logged %q, want %d records", lines, len(tt.want))
+				}
+				for i, level := range tt.want {
+					want := "level=" + level + ` msg="Module mode mismatch" link_command_id=42 error="link command captured with -mod=vendor"`
+					if !strings.Contains(lines[i], want) {
+						t.Errorf("record %d is %q, want %q", i, lines[i], want)
+					}
+				}
+			})
+
+			t.Run("json", func(t *testing.T) {
+				var buf bytes.Buffer
+				logger, err := (&Options{LogLevel: tt.logLevel, LogFormat: "json"}).logger(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { jsonLogger = nil })
+				logRecords(logger.Warn, logger.Info, logger.Debug)
+				// The error ending the program is reported whatever the log level
+				reportError(errors.New("unable to link"))
+
+				var records []map[string]any
+				for dec := json.NewDecoder(&buf); dec.More(); {
+					var record map[string]any
+					if err := dec.Decode(&record); err != nil {
+						t.Fatal(err)
+					}
+					records = append(records, record)
+				}
+				if len(records) != len(tt.want)+1 {
+					t.Fatalf("logged %v, want %d records", records, len(tt.want)+1)
+				}
+				for i, level := range tt.want {
+					for key, want := range map[string]any{"level": level, "msg": "Module mode mismatch", "link_command_id": 42.0, "error": "link command captured with -mod=vendor"} {
+						if records[i][key] != want {
+							t.Errorf("record %d has %s %v, want %v", i, key, records[i][key], want)
+						}
+					}
+				}
+				last := records[len(records)-1]
+				if last["level"] != "ERROR" || last["error"] != "unable to link" {
+					t.Errorf("error reported as %v", last)
+				}
+			})
+		})
+	}
+}
+
+// logRecords logs the same record with each of the log functions.
+func logRecords(logFuncs ...func(msg string, args ...any)) {
+	for _, log := range logFuncs {
+		log("Module mode mismatch", "link_command_id", 42, "error", errors.New("link command captured with -mod=vendor"))
+	}
+}
+
+func TestLoggerUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := (&Options{LogFormat: "xml"}).logger(&buf)
+	var usageErr *UsageError
+	if !errors.As(err, &usageErr) {
+		t.Errorf("logger() error = %v, want a usage error", err)
+	}
+}
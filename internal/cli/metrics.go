@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Metrics records the wall-clock duration of the phases of a run.
+// A nil *Metrics records nothing, so that phases are tracked unconditionally.
+type Metrics struct {
+	start    time.Time
+	mu       sync.Mutex
+	names    []string // Phases in the order they started
+	phases   map[string]time.Duration
+	reported bool
+}
+
+// NewMetrics returns metrics whose total duration starts now.
+func NewMetrics() *Metrics {
+	return &Metrics{start: time.Now(), phases: make(map[string]time.Duration)}
+}
+
+// Track starts a phase and returns the function ending it. The durations of
+// the phases tracked several times under the same name are summed.
+func (m *Metrics) Track(name string) (stop func()) {
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return sync.OnceFunc(func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if !slices.Contains(m.names, name) {
+			m.names = append(m.names, name)
+		}
+		m.phases[name] += time.Since(start)
+	})
+}
+
+// Write writes the duration in seconds of each phase and of the whole run. It
+// only writes them the first time it is called.
+func (m *Metrics) Write(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reported {
+		return nil
+	}
+	m.reported = true
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tSECONDS")
+	for _, name := range m.names {
+		fmt.Fprintf(tw, "%s\t%.6f\n", name, m.phases[name].Seconds())
+	}
+	fmt.Fprintf(tw, "total\t%.6f\n", time.Since(m.start).Seconds())
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write metrics: %w", err)
+	}
+	return nil
+}
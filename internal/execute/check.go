@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+// checkImportcfg compares the hash of the importcfg the link command would be
+// replayed with, after replacements, with the one of the intercepted build.
+// Package files are hashed as stored, so that a DB with paths relative to the
+// Go build cache can be checked anywhere.
+func checkImportcfg(ctx context.Context, tx *sql.Tx, linkCommandID int, replacements map[string]string) (err error) {
+	var capturedHash sql.NullString
+	row := tx.QueryRowContext(ctx, `SELECT importcfg_hash FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&capturedHash); err != nil {
+		return fmt.Errorf("unable to query importcfg hash: %w", err)
+	}
+	if !capturedHash.Valid {
+		return errors.New("the importcfg hash of the link command is unknown, intercept the build again to record it")
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT package, file, NULL
+FROM package_file
+NATURAL JOIN link_command_package_file
+WHERE link_command_id = ?
+UNION ALL
+SELECT NULL, NULL, line
+FROM importcfg_additional_lines
+NATURAL JOIN importcfg_line
+WHERE link_command_id = ?;`,
+		linkCommandID, linkCommandID)
+	if err != nil {
+		return fmt.Errorf("unable to query importcfg: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close importcfg rows: %w", err2))
+		}
+	}()
+
+	var lines, replacedPackages []string
+	for rows.Next() {
+		var packageName, file, line sql.NullString
+		if err := rows.Scan(&packageName, &file, &line); err != nil {
+			return fmt.Errorf("unable to scan importcfg line: %w", err)
+		}
+		if !line.Valid {
+			if replacement, ok := replacements[packageName.String]; ok {
+				replacedPackages = append(replacedPackages, packageName.String)
+				file.String = replacement
+			}
+			line.String = "packagefile " + packageName.String + "=" + file.String
+		}
+		lines = append(lines, line.String)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading importcfg rows: %w", err)
+	}
+
+	for packageName := range replacements {
+		if !slices.Contains(replacedPackages, packageName) {
+			return fmt.Errorf("package %q is not part of the link command", packageName)
+		}
+	}
+
+	if hash := interceptor.ImportcfgHash(lines); hash != capturedHash.String {
+		return fmt.Errorf("importcfg differs from the one of the intercepted build (hash %s instead of %s)", hash, capturedHash.String)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// linkCommandKey identifies a link command across databases.
+type linkCommandKey struct {
+	binaryName      string
+	buildTags       string // Comma-separated
+	platform        string // Like `linux/amd64`
+	instrumentation string
+}
+
+func (k linkCommandKey) String() string {
+	s := k.binaryName + " " + k.platform
+	if k.buildTags != "" {
+		s += " tags=" + k.buildTags
+	}
+	if k.instrumentation != "" {
+		s += " instrumentation=" + k.instrumentation
+	}
+	return s
+}
+
+// storedLinkCommand is what is compared between the link commands of two
+// databases.
+type storedLinkCommand struct {
+	args         []string
+	packageFiles map[string]string // File of each package
+}
+
+// diffDatabases writes the link commands removed, added and changed from the
+// database of tx to the one of otherTx. Nothing is written if they are the
+// same.
+func diffDatabases(ctx context.Context, tx, otherTx *sql.Tx, w io.Writer) error {
+	linkCommands, err := loadLinkCommands(ctx, tx)
+	if err != nil {
+		return err
+	}
+	otherLinkCommands, err := loadLinkCommands(ctx, otherTx)
+	if err != nil {
+		return err
+	}
+
+	keys := slices.Collect(maps.Keys(linkCommands))
+	for key := range otherLinkCommands {
+		if _, ok := linkCommands[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b linkCommandKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		linkCommand, inDB := linkCommands[key]
+		otherLinkCommand, inOtherDB := otherLinkCommands[key]
+		switch {
+		case !inOtherDB:
+			fmt.Fprintf(&buf, "- %s\n", key)
+		case !inDB:
+			fmt.Fprintf(&buf, "+ %s\n", key)
+		default:
+			if changes := diffLinkCommands(linkCommand, otherLinkCommand); len(changes) > 0 {
+				fmt.Fprintf(&buf, "~ %s\n", key)
+				for _, change := range changes {
+					fmt.Fprintf(&buf, "\t%s\n", change)
+				}
+			}
+		}
+	}
+
+	if _, err := buf.WriteTo(w); err != nil {
+		return fmt.Errorf("unable to write differences: %w", err)
+	}
+
+	return nil
+}
+
+// diffLinkCommands returns the arguments and package files removed, added and
+// changed from a link command to another.
+func diffLinkCommands(a, b storedLinkCommand) (changes []string) {
+	if !slices.Equal(a.args, b.args) {
+		removed, added := diffArgs(a.args, b.args)
+		for _, arg := range removed {
+			changes = append(changes, fmt.Sprintf("-arg %q", arg))
+		}
+		for _, arg := range added {
+			changes = append(changes, fmt.Sprintf("+arg %q", arg))
+		}
+		if len(removed) == 0 && len(added) == 0 {
+			changes = append(changes, "arguments reordered")
+		}
+	}
+
+	packages := slices.Collect(maps.Keys(a.packageFiles))
+	for packageName := range b.packageFiles {
+		if _, ok := a.packageFiles[packageName]; !ok {
+			packages = append(packages, packageName)
+		}
+	}
+	slices.Sort(packages)
+	for _, packageName := range packages {
+		file, inA := a.packageFiles[packageName]
+		otherFile, inB := b.packageFiles[packageName]
+		switch {
+		case !inB:
+			changes = append(changes, fmt.Sprintf("-package %s=%s", packageName, file))
+		case !inA:
+			changes = append(changes, fmt.Sprintf("+package %s=%s", packageName, otherFile))
+		case file != otherFile:
+			changes = append(changes, fmt.Sprintf("~package %s: %s -> %s", packageName, file, otherFile))
+		}
+	}
+
+	return changes
+}
+
+// diffArgs returns the arguments of a missing from b and those of b missing
+// from a, in order. Repeated arguments are counted.
+func diffArgs(a, b []string) (removed, added []string) {
+	counts := make(map[string]int)
+	for _, arg := range b {
+		counts[arg]++
+	}
+	for _, arg := range a {
+		if counts[arg] > 0 {
+			counts[arg]--
+		} else {
+			removed = append(removed, arg)
+		}
+	}
+
+	counts = make(map[string]int)
+	for _, arg := range a {
+		counts[arg]++
+	}
+	for _, arg := range b {
+		if counts[arg] > 0 {
+			counts[arg]--
+		} else {
+			added = append(added, arg)
+		}
+	}
+
+	return removed, added
+}
+
+// loadLinkCommands returns the arguments and the package files of all the link
+// commands of the database.
+func loadLinkCommands(ctx context.Context, tx *sql.Tx) (linkCommands map[linkCommandKey]storedLinkCommand, err error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT link_command_id, binary_name, json(tags), goos, goarch, instrumentation, json(args)
+FROM link_command
+NATURAL JOIN build_tags;`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query link commands: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close link commands rows: %w", err2))
+		}
+	}()
+
+	keys := make(map[int64]linkCommandKey)
+	linkCommands = make(map[linkCommandKey]storedLinkCommand)
+	for rows.Next() {
+		var linkCommandID int64
+		var key linkCommandKey
+		var goos, goarch, buildTagsJSON, argsJSON string
+		if err := rows.Scan(&linkCommandID, &key.binaryName, &buildTagsJSON, &goos, &goarch, &key.instrumentation, &argsJSON); err != nil {
+			return nil, fmt.Errorf("unable to scan link command: %w", err)
+		}
+		var buildTags []string
+		if err := json.Unmarshal([]byte(buildTagsJSON), &buildTags); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal build tags: %w", err)
+		}
+		key.buildTags = strings.Join(buildTags, ",")
+		key.platform = goos + "/" + goarch
+
+		linkCommand := storedLinkCommand{packageFiles: make(map[string]string)}
+		if err := json.Unmarshal([]byte(argsJSON), &linkCommand.args); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal link command arguments: %w", err)
+		}
+		keys[linkCommandID] = key
+		linkCommands[key] = linkCommand
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading link commands rows: %w", err)
+	}
+
+	packageFiles, err := tx.QueryContext(ctx, `
+SELECT link_command_id, package, file
+FROM link_command_package_file
+NATURAL JOIN package_file;`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query package files: %w", err)
+	}
+	defer func() {
+		if err2 := packageFiles.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close package files rows: %w", err2))
+		}
+	}()
+
+	for packageFiles.Next() {
+		var linkCommandID int64
+		var packageName, file string
+		if err := packageFiles.Scan(&linkCommandID, &packageName, &file); err != nil {
+			return nil, fmt.Errorf("unable to scan package file: %w", err)
+		}
+		linkCommands[keys[linkCommandID]].packageFiles[packageName] = file
+	}
+	if err := packageFiles.Err(); err != nil {
+		return nil, fmt.Errorf("error reading package files rows: %w", err)
+	}
+
+	return linkCommands, nil
+}
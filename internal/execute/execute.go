@@ -0,0 +1,1137 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package execute implements the executor, which relinks a binary from the
+// link command stored by the interceptor and executes it.
+package execute
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+	"github.com/L3n41c/golinkinterceptor/internal/sqlite"
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+var logger = slog.Default()
+
+// exitMissingPackageFiles is the exit status when object files referenced by
+// the link command have been removed from the Go build cache.
+const exitMissingPackageFiles = 3
+
+// exitNoLinkCommand is the exit status when no link command matches the
+// binary, its build tags and its target platform, with -json.
+const exitNoLinkCommand = 4
+
+// Run links the binary given by the command line arguments cmdLine and
+// executes it. It only returns on failure or when the binary isn’t executed.
+// The flags are registered on fs. The common options are parsed from cmdLine
+// as well when opts is nil.
+// stop stops relaying the interrupt signals once the linker is done.
+func Run(ctx context.Context, stop context.CancelFunc, fs *flag.FlagSet, cmdLine []string, opts *cli.Options) (err error) {
+	config, err := parseConfig(ctx, fs, cmdLine, opts)
+	if err != nil {
+		return fmt.Errorf("unable to parse config: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, config.metrics.Write(os.Stderr))
+	}()
+	stopQuery := config.metrics.Track("query")
+	defer stopQuery()
+
+	db, err := openDB(ctx, config.dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if config.diff != "" {
+		otherDB, err := openDB(ctx, config.diff)
+		if err != nil {
+			return err
+		}
+		defer otherDB.Close()
+		otherTx, err := otherDB.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("unable to begin transaction: %w", err)
+		}
+		defer otherTx.Rollback() //nolint:errcheck
+
+		if err := diffDatabases(ctx, tx, otherTx, os.Stdout); err != nil {
+			return fmt.Errorf("unable to compare databases: %w", err)
+		}
+		return nil
+	}
+
+	if config.validate {
+		problems, err := validateDB(ctx, tx, os.Stdout)
+		if err != nil {
+			return fmt.Errorf("unable to validate database: %w", err)
+		}
+		if problems > 0 {
+			return fmt.Errorf("%d problems found in database %s", problems, config.dbPath)
+		}
+		logger.Info("No problems found in database", "path", config.dbPath)
+		return nil
+	}
+
+	if config.stats {
+		if err := printStats(ctx, tx, config.dbPath, os.Stdout, config.json); err != nil {
+			return fmt.Errorf("unable to print statistics: %w", err)
+		}
+		return nil
+	}
+
+	if config.list {
+		if err := listLinkCommands(ctx, tx, os.Stdout, config.json); err != nil {
+			return fmt.Errorf("unable to list link commands: %w", err)
+		}
+		return nil
+	}
+
+	if config.match != nil {
+		if config.binaryName, err = matchBinaryName(ctx, tx, config); err != nil {
+			return err
+		}
+		if config.argv0 == "" {
+			config.argv0 = config.binaryName
+		}
+		logger.Debug("Binary matched", "match", config.match.String(), "binary", config.binaryName)
+	}
+
+	linkCommandID, mainPackage, err := getLinkCommandID(ctx, tx, config)
+	var notFound *noLinkCommandError
+	if config.json && errors.As(err, &notFound) {
+		if err := reportNoLinkCommand(ctx, tx, os.Stdout, notFound); err != nil {
+			return fmt.Errorf("unable to report missing link command: %w", err)
+		}
+		return &cli.ExitError{Code: exitNoLinkCommand}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get link command ID: %w", err)
+	}
+
+	// There’s no linker to compare with when dumping the importcfg
+	if config.linker != "" {
+		if err := checkGoVersion(ctx, tx, linkCommandID, config.linker); err != nil {
+			if config.strict {
+				return err
+			}
+			logger.Warn("Go version mismatch", "link_command_id", linkCommandID, "error", err)
+		}
+	}
+
+	if err := checkModMode(ctx, tx, linkCommandID, config.modMode); err != nil {
+		logger.Warn("Module mode mismatch", "link_command_id", linkCommandID, "error", err)
+	}
+
+	buildMode, err := checkBuildMode(ctx, tx, linkCommandID, config.buildMode)
+	if err != nil {
+		return err
+	}
+	// Libraries can only be written to a file, in a directory of their own
+	// unless -o or -keep-temp is given
+	var libraryDir string
+	if !isExecutable(buildMode) && config.output == "" && !config.keepTemp && !config.dryRun && config.dumpImportcfg == "" && !config.check && config.verify == "" {
+		if libraryDir, err = os.MkdirTemp(config.tmpDir, "golinkinterceptor-"); err != nil {
+			return fmt.Errorf("unable to create directory for the library: %w", err)
+		}
+		config.output = filepath.Join(libraryDir, filepath.Base(config.binaryName))
+	}
+
+	if config.verifyFiles {
+		missing, err := getMissingPackageFiles(ctx, tx, linkCommandID, config.replacements, config.pathMap)
+		if err != nil {
+			return fmt.Errorf("unable to verify package files: %w", err)
+		}
+		if len(missing) > 0 {
+			for _, m := range missing {
+				fmt.Fprintf(os.Stderr, "Missing object file of package %s: %s\n", m.packageName, m.file)
+			}
+			fmt.Fprintln(os.Stderr, "The Go build cache was probably trimmed. Run the interceptor again to rebuild the binary.")
+			return &cli.ExitError{Code: exitMissingPackageFiles}
+		}
+	}
+
+	if config.check {
+		if err := checkImportcfg(ctx, tx, linkCommandID, config.replacements); err != nil {
+			return err
+		}
+		fmt.Println("Importcfg matches the intercepted build")
+		return nil
+	}
+
+	if config.dumpImportcfg != "" {
+		if _, _, err := getImportcfg(ctx, tx, linkCommandID, config.replacements, config.pathMap, config.tmpDir, config.dumpImportcfg); err != nil {
+			return fmt.Errorf("unable to dump importcfg: %w", err)
+		}
+		logger.Info("Importcfg written", "path", config.dumpImportcfg)
+		return nil
+	}
+
+	if err := checkWorkDir(ctx, tx, linkCommandID); err != nil {
+		return err
+	}
+
+	// The kept files are written to a directory of their own, under stable names
+	var keepDir, keptImportcfgFileName string
+	if config.keepTemp {
+		if keepDir, err = os.MkdirTemp(config.tmpDir, "golinkinterceptor-"); err != nil {
+			return fmt.Errorf("unable to create directory for the kept files: %w", err)
+		}
+		keptImportcfgFileName = filepath.Join(keepDir, "importcfg.link")
+	}
+
+	stopQuery()
+	stopImportcfg := config.metrics.Track("importcfg")
+	importcfgFileName, replacedFiles, err := getImportcfg(ctx, tx, linkCommandID, config.replacements, config.pathMap, config.tmpDir, keptImportcfgFileName)
+	if err != nil {
+		return fmt.Errorf("unable to get importcfg: %w", err)
+	}
+	stopImportcfg()
+	if config.keepTemp {
+		fmt.Fprintf(os.Stderr, "Importcfg kept at %s\n", importcfgFileName)
+	} else {
+		defer func() {
+			if err2 := os.Remove(importcfgFileName); err2 != nil && !os.IsNotExist(err2) {
+				err = errors.Join(err, fmt.Errorf("unable to remove importcfg file: %w", err2))
+			}
+		}()
+	}
+	if file, ok := replacedFiles[mainPackage]; ok {
+		mainPackage = file
+	}
+
+	binaryFileName := config.output
+	if binaryFileName == "" && config.keepTemp {
+		binaryFileName = filepath.Join(keepDir, filepath.Base(config.binaryName))
+		fmt.Fprintf(os.Stderr, "Binary kept at %s\n", binaryFileName)
+	} else if binaryFileName == "" {
+		binaryFile, err := os.CreateTemp(config.tmpDir, filepath.Base(config.binaryName))
+		if err != nil {
+			return fmt.Errorf("unable to create binary file: %w", err)
+		}
+		binaryFileName = binaryFile.Name()
+		// The binary is only kept when it replaces the executor, which never returns
+		defer func() {
+			if err2 := os.Remove(binaryFileName); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to remove binary file: %w", err2))
+			}
+		}()
+		if err := binaryFile.Close(); err != nil {
+			return fmt.Errorf("unable to close binary file: %w", err)
+		}
+	}
+
+	var extraArgs []string
+	for _, definition := range config.definitions {
+		extraArgs = append(extraArgs, "-X", definition)
+	}
+
+	args, err := getLinkerCommandArgs(ctx, tx, linkCommandID, mainPackage, binaryFileName, importcfgFileName, extraArgs)
+	if err != nil {
+		return fmt.Errorf("unable to get link command args: %w", err)
+	}
+	if config.extld != "" {
+		args = replaceExtld(args, config.extld)
+	}
+	if config.noBuildID {
+		args = removeBuildID(args)
+	}
+
+	if config.dryRun {
+		if err := printLinkCommand(os.Stdout, config.linker, args, importcfgFileName); err != nil {
+			return fmt.Errorf("dry run failed: %w", err)
+		}
+		return nil
+	}
+
+	// Invoke the linker
+	logger.Info("Link command", "link_command_id", linkCommandID, "linker", config.linker, "args", args)
+	linkCmd := linkerCommand(ctx, config.linker, args)
+	if config.restoreEnv {
+		env, err := getBuildEnv(ctx, tx, linkCommandID)
+		if err != nil {
+			return err
+		}
+		logger.Info("Restoring build environment", "env", env)
+		if linkCmd.Env == nil {
+			linkCmd.Env = os.Environ()
+		}
+		linkCmd.Env = append(linkCmd.Env, env...)
+	}
+	// The warnings of a successful link are only logged
+	stopLink := config.metrics.Track("link")
+	var linkStderr bytes.Buffer
+	linkCmd.Stdin = os.Stdin
+	linkCmd.Stdout = os.Stdout
+	linkCmd.Stderr = &linkStderr
+	if err := linkCmd.Run(); err != nil {
+		os.Stderr.Write(linkStderr.Bytes()) //nolint:errcheck
+		if ctx.Err() != nil {
+			return fmt.Errorf("linker command interrupted: %w", context.Cause(ctx))
+		}
+		// The linker already reported why it failed
+		if err, ok := err.(*exec.ExitError); ok {
+			return &cli.ExitError{Code: err.ExitCode()}
+		}
+		return fmt.Errorf("linker command failed: %w", err)
+	}
+	if linkStderr.Len() > 0 {
+		logger.Debug("Linker output", "stderr", linkStderr.String())
+	}
+
+	if hasBuildID(args) {
+		if err := rewriteBuildID(ctx, binaryFileName); err != nil {
+			return err
+		}
+	}
+	stopLink()
+
+	if config.verify != "" {
+		return verifyBinary(ctx, tx, linkCommandID, config, config.verify, binaryFileName, os.Stdout)
+	}
+
+	// The file created by os.CreateTemp isn’t executable
+	if err := os.Chmod(binaryFileName, 0o755); err != nil { //nolint:gosec
+		return fmt.Errorf("unable to make binary executable: %w", err)
+	}
+	if config.codesign && isExecutable(buildMode) {
+		if err := codesign(ctx, config.codesignIdent, binaryFileName); err != nil {
+			return err
+		}
+	}
+
+	if config.output != "" {
+		if libraryDir != "" {
+			fmt.Fprintf(os.Stderr, "%s is linked with -buildmode=%s and can’t be executed, written to %s\n", config.binaryName, buildMode, config.output)
+		}
+		logger.Info("Binary written", "path", config.output)
+		return nil
+	}
+
+	// Libraries are kept along with the importcfg
+	if !isExecutable(buildMode) {
+		return nil
+	}
+
+	// Deferred functions don’t run when the binary is executed
+	if !config.keepTemp {
+		if err := os.Remove(importcfgFileName); err != nil {
+			return fmt.Errorf("unable to remove importcfg file: %w", err)
+		}
+	}
+
+	// Unless spawned, the binary replaces the executor where the platform allows
+	runner := newExecRunner(stop)
+	if config.spawn {
+		runner = spawnRunner{}
+		defer config.metrics.Track("run")()
+	} else if err := config.metrics.Write(os.Stderr); err != nil {
+		return err
+	}
+	return runner.run(ctx, binaryFileName, append([]string{config.argv0}, config.args...))
+}
+
+// openDB opens the database read-only. Opening is lazy, so the database is
+// queried once to report a missing or corrupt file before anything else.
+func openDB(ctx context.Context, dbPath string) (*sql.DB, error) {
+	if dbPath == interceptor.MemoryDB {
+		return nil, fmt.Errorf("database %q is always empty; run the interceptor with -export and -import its output in a file DB", dbPath)
+	}
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("database %q not found; run the interceptor first", dbPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to access database %q: %w", dbPath, err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_foreign_keys=true")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database %q: %w", dbPath, err)
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		if sqlite.IsCorrupt(err) {
+			err = fmt.Errorf("database %q is corrupt or isn’t a link database: %w", dbPath, err)
+		} else {
+			err = fmt.Errorf("unable to read database %q: %w", dbPath, err)
+		}
+		return nil, errors.Join(err, db.Close())
+	}
+	// The interceptor creates the schema along with the file
+	if version == 0 {
+		return nil, errors.Join(fmt.Errorf("database %q is empty; run the interceptor first", dbPath), db.Close())
+	}
+	// The database is opened read-only, its schema is upgraded by the
+	// interceptor
+	if latest := interceptor.SchemaVersion(); version != latest {
+		return nil, errors.Join(fmt.Errorf("database %q has schema version %d instead of %d; run the interceptor of the same version as the executor to upgrade it", dbPath, version, latest), db.Close())
+	}
+
+	return db, nil
+}
+
+type Config struct {
+	dbPath          string
+	linker          string
+	binaryName      string
+	match           *regexp.Regexp // Matches the stored name of the binary instead of binaryName
+	selectLatest    bool           // Select the latest link command stored among those matching
+	anyVariant      variantFilter  // Variant criteria not given on the command line, ignored by -select-latest
+	argv0           string         // Name the binary is executed as
+	buildTags       []string
+	goos            string
+	goarch          string
+	replacements    map[string]string
+	pathMap         pathMap // Prefixes of the package file paths rewritten before linking
+	definitions     []string
+	extld           string
+	modMode         string
+	output          string
+	dryRun          bool
+	dumpImportcfg   string
+	check           bool
+	verify          string
+	buildMode       string
+	instrumentation string // Sorted and comma-separated like in the DB
+	keepTemp        bool
+	spawn           bool // Run the binary as a child process instead of executing it
+	noBuildID       bool
+	codesign        bool   // Sign the binary before it’s run or written
+	tmpDir          string // Directory of the temporary files, the default one of the OS if empty
+	codesignIdent   string // Identity the binary is signed with, `-` for ad-hoc
+	restoreEnv      bool   // Run the linker with the Go environment of the build
+	verifyFiles     bool
+	strict          bool
+	list            bool
+	stats           bool
+	validate        bool
+	diff            string // Path of the DB compared with the one of dbPath
+	json            bool
+	metrics         *cli.Metrics // Nil unless -metrics is given
+	args            []string
+}
+
+func parseConfig(ctx context.Context, fs *flag.FlagSet, args []string, opts *cli.Options) (config Config, err error) {
+	if opts == nil {
+		opts = &cli.Options{}
+		opts.AddFlags(fs)
+	}
+	fs.StringVar(&config.linker, "link", "", "File path to the linker executable (defaults to the linker in `go env GOTOOLDIR`)")
+	tags := fs.String("tags", "", "Build tags to use (defaults to the -tags flag of go env GOFLAGS)")
+	fs.StringVar(&config.goos, "goos", runtime.GOOS, "Target operating system of the binary")
+	fs.StringVar(&config.goarch, "goarch", runtime.GOARCH, "Target architecture of the binary")
+	match := fs.String("match", "", "Regular expression matching the name of a single binary stored in the DB, instead of giving its name before its arguments")
+	fs.BoolVar(&config.selectLatest, "select-latest", false, "Select the link command stored last among those matching, with any build tags, platform or instrumentation unless given by their flags")
+	fs.StringVar(&config.output, "o", "", "Write the linked binary to this path instead of executing it")
+	fs.StringVar(&config.argv0, "argv0", "", "Name the binary is executed as, for programs behaving according to it (defaults to the executable name)")
+	fs.BoolVar(&config.dryRun, "dry-run", false, "Print the link command and the importcfg instead of linking and executing the binary")
+	fs.StringVar(&config.verify, "verify", "", "Compare the linked binary with a fresh go build of this `package` instead of executing it. They differ if the sources, the toolchain or the VCS status changed since the capture, or with -no-buildid, -X, -extld, -replace or -path-map")
+	fs.StringVar(&config.dumpImportcfg, "dump-importcfg", "", "Write the importcfg to this path instead of linking and executing the binary")
+	fs.BoolVar(&config.check, "check", false, "Check that the importcfg is the one of the intercepted build instead of linking and executing the binary")
+	config.replacements = make(map[string]string)
+	fs.Var(mapFlag(config.replacements), "replace", "Use another object file for a package (package=path, can be repeated)")
+	fs.Var(&config.pathMap, "path-map", "Rewrite the prefix of the package file paths, like when the Go build cache is mounted elsewhere (old=new, can be repeated)")
+	fs.Var((*listFlag)(&config.definitions), "X", "Set the value of a string variable (importpath.name=value, can be repeated)")
+	fs.StringVar(&config.modMode, "mod", "", "Module mode of the replay environment, compared with the one the link command was captured with (defaults to the -mod flag of go env GOFLAGS)")
+	race := fs.Bool("race", false, "Use the link command captured with -race")
+	msan := fs.Bool("msan", false, "Use the link command captured with -msan")
+	asan := fs.Bool("asan", false, "Use the link command captured with -asan")
+	fs.StringVar(&config.buildMode, "buildmode", "", "Build mode the link command must have been captured with, any if empty")
+	fs.StringVar(&config.extld, "extld", "", "Use this external linker instead of the one the link command was captured with")
+	fs.BoolVar(&config.restoreEnv, "restore-env", false, "Run the linker with the Go environment variables recorded during the build, like GOEXPERIMENT")
+	fs.BoolVar(&config.noBuildID, "no-buildid", false, "Link the binary without build ID instead of recomputing the one of the link command")
+	fs.BoolVar(&config.codesign, "codesign", runtime.GOOS == "darwin" || runtime.GOOS == "ios", "Sign the binary with codesign before running or writing it, which macOS requires (defaults to true on darwin and ios)")
+	fs.StringVar(&config.codesignIdent, "codesign-identity", "-", "Identity the binary is signed with by -codesign, - for an ad-hoc signature")
+	fs.BoolVar(&config.spawn, "spawn", false, "Run the binary as a child process and exit with its status instead of executing it in place of the executor")
+	fs.StringVar(&config.tmpDir, "tmpdir", "", "Directory of the temporary files, like the importcfg and the binary, which must allow executing the binary (defaults to $TMPDIR)")
+	fs.BoolVar(&config.keepTemp, "keep-temp", false, "Keep the importcfg and the linked binary in a temporary directory and print their paths")
+	fs.BoolVar(&config.verifyFiles, "verify-files", true, "Check that the object files of all the packages still exist before linking")
+	fs.BoolVar(&config.strict, "strict", false, "Fail instead of warning when the linker version differs from the Go version the link command was captured with")
+	fs.BoolVar(&config.list, "list", false, "List the binaries stored in the DB instead of linking one")
+	fs.StringVar(&config.diff, "diff", "", "Print the link commands removed, added or changed from the DB to this other DB instead of linking a binary")
+	fs.BoolVar(&config.validate, "validate", false, "Check the integrity of the DB and report the link commands left incomplete instead of linking a binary")
+	fs.BoolVar(&config.stats, "stats", false, "Print statistics about the DB and the sharing of package files instead of linking a binary")
+	fs.BoolVar(&config.json, "json", false, "Use JSON for the output of -list and -stats and for the error reported when the binary isn’t in the DB")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	config.dbPath = opts.DBPath
+	visited := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { visited[f.Name] = true })
+
+	// The executor is used as a transparent linker shim, it only reports warnings
+	// by default
+	if logger, err = opts.Logger(); err != nil {
+		return Config{}, err
+	}
+	interceptor.Logger = logger
+	if err := opts.SetGoCache(); err != nil {
+		return Config{}, err
+	}
+
+	// The defaults are those of the build, which the interceptor gets from
+	// `go env GOFLAGS` rather than from the environment
+	if !visited["tags"] || !visited["mod"] {
+		goFlags, err := interceptor.GoFlags(ctx)
+		if err != nil {
+			logger.Debug("Using the GOFLAGS environment variable", "error", err)
+			goFlags = strings.Fields(os.Getenv("GOFLAGS"))
+		}
+		if !visited["tags"] {
+			goFlagsBuildTags, _ := interceptor.BuildTagsFlag(goFlags)
+			*tags = strings.Join(goFlagsBuildTags, ",")
+		}
+		if !visited["mod"] {
+			config.modMode = interceptor.ModMode(goFlags)
+		}
+	}
+
+	if config.selectLatest {
+		// The build tags of GOFLAGS are given like those of -tags
+		config.anyVariant = variantFilter{
+			tags:            !visited["tags"] && *tags == "",
+			goos:            !visited["goos"],
+			goarch:          !visited["goarch"],
+			instrumentation: !visited["race"] && !visited["msan"] && !visited["asan"],
+		}
+	}
+	if opts.Metrics {
+		config.metrics = cli.NewMetrics()
+	}
+
+	if *match != "" {
+		if config.match, err = regexp.Compile(*match); err != nil {
+			return Config{}, &cli.UsageError{Msg: fmt.Sprintf("Invalid -match regular expression: %v", err)}
+		}
+		// All the arguments are passed to the binary
+		config.args = fs.Args()
+	} else if fs.NArg() < 1 && !config.list && !config.stats && !config.validate && config.diff == "" {
+		return Config{}, &cli.UsageError{Msg: "Need an executable name"}
+	}
+
+	if fs.NArg() > 0 && config.match == nil {
+		config.binaryName = fs.Arg(0)
+		if config.argv0 == "" {
+			config.argv0 = config.binaryName
+		}
+		config.args = fs.Args()[1:]
+	}
+	config.buildTags = interceptor.ParseBuildTags(*tags)
+	var instrumentation []string
+	for _, i := range []struct {
+		name    string
+		enabled bool
+	}{{"asan", *asan}, {"msan", *msan}, {"race", *race}} {
+		if i.enabled {
+			instrumentation = append(instrumentation, i.name)
+		}
+	}
+	config.instrumentation = strings.Join(instrumentation, ",")
+
+	// Listing, printing statistics, validating or comparing DBs and dumping or
+	// checking the importcfg don’t need a linker
+	if config.linker == "" && !config.list && !config.stats && !config.validate && config.diff == "" && config.dumpImportcfg == "" && !config.check {
+		if config.linker, err = interceptor.Linker(ctx); err != nil {
+			return Config{}, fmt.Errorf("unable to find the linker, use -link to set it: %w", err)
+		}
+		logger.Debug("Using linker", "linker", config.linker)
+	}
+	if config.linker != "" {
+		if err := checkLinker(config.linker); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return
+}
+
+// checkLinker fails if the linker isn’t an executable file, before any work is
+// done to relink.
+func checkLinker(linker string) error {
+	info, err := os.Stat(linker)
+	if err != nil {
+		return fmt.Errorf("unable to use linker %q: %w", linker, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("unable to use linker %q: is a directory", linker)
+	}
+	// Windows has no executable permission bits to check
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("unable to use linker %q: not executable", linker)
+	}
+	return nil
+}
+
+// mapFlag is a repeatable command line flag of the form `key=value`.
+type mapFlag map[string]string
+
+func (m mapFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m mapFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" || v == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	m[k] = v
+	return nil
+}
+
+// pathMap is a repeatable command line flag of the form `old=new` rewriting
+// the prefix old of paths to new. The first matching prefix is used.
+type pathMap []pathMapping
+
+type pathMapping struct {
+	old string
+	new string
+}
+
+func (m *pathMap) String() string {
+	pairs := make([]string, 0, len(*m))
+	for _, mapping := range *m {
+		pairs = append(pairs, mapping.old+"="+mapping.new)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *pathMap) Set(value string) error {
+	oldPrefix, newPrefix, ok := strings.Cut(value, "=")
+	if !ok || oldPrefix == "" || newPrefix == "" {
+		return fmt.Errorf("expected old=new, got %q", value)
+	}
+	*m = append(*m, pathMapping{filepath.Clean(oldPrefix), filepath.Clean(newPrefix)})
+	return nil
+}
+
+// expand returns the path of a package file expanded by
+// interceptor.ExpandCachePath with its prefix rewritten.
+// A prefix only matches whole path elements.
+func (m pathMap) expand(ctx context.Context, file string) (string, error) {
+	file, err := interceptor.ExpandCachePath(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	for _, mapping := range m {
+		if rel, ok := strings.CutPrefix(file, mapping.old); ok && (rel == "" || os.IsPathSeparator(rel[0])) {
+			return mapping.new + rel, nil
+		}
+	}
+	return file, nil
+}
+
+// listFlag is a repeatable command line flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// variantFilter tells which criteria of the variant of a binary are ignored
+// when looking it up.
+type variantFilter struct {
+	tags            bool
+	goos            bool
+	goarch          bool
+	instrumentation bool
+}
+
+func getLinkCommandID(ctx context.Context, tx *sql.Tx, config Config) (linkCommandID int, mainPackage string, err error) {
+	// Relative binary names are first looked up as they were given to the
+	// interceptor, then as paths relative to the current directory
+	binaryName := filepath.Clean(config.binaryName)
+	binaryPath, err := filepath.Abs(binaryName)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to get absolute path of %s: %w", config.binaryName, err)
+	}
+	// The link commands stored before the time was recorded are the oldest
+	var canonical, goos, goarch, instrumentation string
+	row := tx.QueryRowContext(ctx, `
+SELECT link_command_id, package_file.file, canonical, goos, goarch, instrumentation
+FROM link_command
+NATURAL JOIN build_tags
+LEFT JOIN package_file ON link_command.main_package_id = package_file.package_file_id
+WHERE (binary_name IN (?, ?) OR binary_path = ?) AND (? OR canonical = ?) AND (? OR goos = ?) AND (? OR goarch = ?) AND (? OR instrumentation = ?)
+ORDER BY binary_name IN (?, ?) DESC, updated_at IS NULL, updated_at DESC, link_command_id DESC
+LIMIT 1;`,
+		config.binaryName, binaryName, binaryPath, config.anyVariant.tags, interceptor.CanonicalBuildTags(config.buildTags), config.anyVariant.goos, config.goos,
+		config.anyVariant.goarch, config.goarch, config.anyVariant.instrumentation, config.instrumentation, config.binaryName, binaryName)
+	if err := row.Scan(&linkCommandID, &mainPackage, &canonical, &goos, &goarch, &instrumentation); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", &noLinkCommandError{BinaryName: config.binaryName, BuildTags: config.buildTags, GOOS: config.goos, GOARCH: config.goarch, Instrumentation: config.instrumentation}
+		}
+		return 0, "", fmt.Errorf("unable to query link command ID: %w", err)
+	}
+	if config.selectLatest {
+		logger.Info("Selected latest link command", "link_command_id", linkCommandID, "build_tags", canonical, "goos", goos, "goarch", goarch, "instrumentation", instrumentation)
+	}
+	if mainPackage, err = config.pathMap.expand(ctx, mainPackage); err != nil {
+		return 0, "", fmt.Errorf("unable to expand main package path: %w", err)
+	}
+
+	return
+}
+
+// checkGoVersion compares the version of the linker with the version of the Go
+// toolchain the link command was captured with.
+// The object files of a Go release can’t always be linked by another one.
+func checkGoVersion(ctx context.Context, tx *sql.Tx, linkCommandID int, linker string) error {
+	var goVersion sql.NullString
+	row := tx.QueryRowContext(ctx, `SELECT go_version FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&goVersion); err != nil {
+		return fmt.Errorf("unable to query Go version: %w", err)
+	}
+	if !goVersion.Valid {
+		logger.Info("Unknown Go version of the link command, skipping the version check", "link_command_id", linkCommandID)
+		return nil
+	}
+
+	// Prints `link version go1.23.4` followed by the enabled experiments
+	out, err := exec.CommandContext(ctx, linker, "-V").Output() //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("unable to get linker version: %w", err)
+	}
+	fields := strings.Fields(strings.TrimPrefix(string(out), "link version "))
+	if len(fields) == 0 {
+		return fmt.Errorf("unable to parse linker version %q", out)
+	}
+
+	if fields[0] != goVersion.String {
+		return fmt.Errorf("link command captured with %s but the linker is %s", goVersion.String, fields[0])
+	}
+
+	return nil
+}
+
+// linkerCommand returns the command running the linker with args.
+func linkerCommand(ctx context.Context, linker string, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, linker, args...) //nolint:gosec
+	if goroot := linkerGOROOT(linker); goroot != "" {
+		// `go build` sets it and the linker embeds it in the binary
+		cmd.Env = append(os.Environ(), "GOROOT="+goroot)
+	}
+	return cmd
+}
+
+// getBuildEnv returns the environment variables of the Go toolchain recorded
+// along with the link command, as `name=value` pairs.
+func getBuildEnv(ctx context.Context, tx *sql.Tx, linkCommandID int) ([]string, error) {
+	var envJSON sql.NullString
+	row := tx.QueryRowContext(ctx, `SELECT json(env) FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&envJSON); err != nil {
+		return nil, fmt.Errorf("unable to query build environment: %w", err)
+	}
+	if !envJSON.Valid {
+		return nil, errors.New("the build environment of the link command is unknown, intercept the build again to record it")
+	}
+
+	var env map[string]string
+	if err := json.Unmarshal([]byte(envJSON.String), &env); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal build environment: %w", err)
+	}
+	var pairs []string
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		pairs = append(pairs, name+"="+env[name])
+	}
+
+	return pairs, nil
+}
+
+// linkerGOROOT returns the GOROOT of a linker located in the tool directory
+// of a Go installation, or an empty string.
+func linkerGOROOT(linker string) string {
+	toolDir := filepath.Dir(linker)
+	if filepath.Base(filepath.Dir(toolDir)) != "tool" || filepath.Base(filepath.Dir(filepath.Dir(toolDir))) != "pkg" {
+		return ""
+	}
+	return filepath.Dir(filepath.Dir(filepath.Dir(toolDir)))
+}
+
+// checkWorkDir fails if arguments of the link command reference the temporary
+// work directory of the intercepted build, which has been removed since.
+func checkWorkDir(ctx context.Context, tx *sql.Tx, linkCommandID int) error {
+	var workDir sql.NullString
+	var argsJSON string
+	row := tx.QueryRowContext(ctx, `SELECT work_dir, json(args) FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&workDir, &argsJSON); err != nil {
+		return fmt.Errorf("unable to query work directory: %w", err)
+	}
+	if workDir.String == "" {
+		return nil
+	}
+
+	var args []string
+	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+		return fmt.Errorf("unable to unmarshal link command arguments: %w", err)
+	}
+	var workDirArgs []string
+	for _, arg := range args {
+		if strings.Contains(arg, workDir.String) {
+			workDirArgs = append(workDirArgs, arg)
+		}
+	}
+	if len(workDirArgs) > 0 {
+		return fmt.Errorf("link command arguments reference the work directory %s of the intercepted build, which has been removed:\n\t%s", workDir.String, strings.Join(workDirArgs, "\n\t"))
+	}
+
+	return nil
+}
+
+// checkBuildMode returns the build mode the link command was captured with and
+// fails if it isn’t the expected one.
+func checkBuildMode(ctx context.Context, tx *sql.Tx, linkCommandID int, expected string) (string, error) {
+	var buildMode string
+	row := tx.QueryRowContext(ctx, `SELECT buildmode FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&buildMode); err != nil {
+		return "", fmt.Errorf("unable to query build mode: %w", err)
+	}
+
+	if expected != "" && buildMode != expected {
+		return "", fmt.Errorf("link command captured with -buildmode=%s but -buildmode=%s is expected", buildMode, expected)
+	}
+
+	return buildMode, nil
+}
+
+// isExecutable reports whether the build mode produces an executable rather
+// than a library.
+func isExecutable(buildMode string) bool {
+	switch buildMode {
+	case "c-archive", "c-shared", "plugin":
+		return false
+	}
+	return true
+}
+
+// checkModMode compares the module mode of the replay environment with the
+// one the link command was captured with, unless it is unknown.
+// The module mode changes the packages the object files are built from.
+func checkModMode(ctx context.Context, tx *sql.Tx, linkCommandID int, modMode string) error {
+	var capturedModMode sql.NullString
+	row := tx.QueryRowContext(ctx, `SELECT mod_mode FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&capturedModMode); err != nil {
+		return fmt.Errorf("unable to query module mode: %w", err)
+	}
+	// The link commands captured without -mod, or before it was recorded,
+	// were built in the default module mode of their module
+	if !capturedModMode.Valid || capturedModMode.String == "" {
+		logger.Info("Unknown module mode of the link command, skipping the module mode check", "link_command_id", linkCommandID)
+		return nil
+	}
+
+	if capturedModMode.String != modMode {
+		return fmt.Errorf("link command captured with -mod=%s but replayed with -mod=%s", capturedModMode.String, modMode)
+	}
+
+	return nil
+}
+
+// packageFile is a package and its object file.
+type packageFile struct {
+	packageName string
+	file        string
+}
+
+// getMissingPackageFiles returns the package files of the link command whose
+// object file doesn’t exist anymore.
+// Replaced packages are ignored.
+func getMissingPackageFiles(ctx context.Context, tx *sql.Tx, linkCommandID int, replacements map[string]string, pathMap pathMap) (missing []packageFile, err error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT package, file
+FROM package_file
+NATURAL JOIN link_command_package_file
+WHERE link_command_id = ?
+ORDER BY package;`,
+		linkCommandID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query package files: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close package files rows: %w", err2))
+		}
+	}()
+
+	for rows.Next() {
+		var packageName, file string
+		if err := rows.Scan(&packageName, &file); err != nil {
+			return nil, fmt.Errorf("unable to scan package file: %w", err)
+		}
+		if _, ok := replacements[packageName]; ok {
+			continue
+		}
+		if file, err = pathMap.expand(ctx, file); err != nil {
+			return nil, fmt.Errorf("unable to expand object file path of package %s: %w", packageName, err)
+		}
+		if _, err := os.Stat(file); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("unable to stat object file of package %s: %w", packageName, err)
+			}
+			missing = append(missing, packageFile{packageName, file})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading package files rows: %w", err)
+	}
+
+	return
+}
+
+// getImportcfg writes the importcfg of the link command to fileName, or to a
+// temporary file in tmpDir if it is empty.
+// The object files of the packages listed in replacements are substituted and
+// the substituted files are returned, keyed by their original path.
+// The prefixes of the paths of the other object files are rewritten by pathMap.
+func getImportcfg(ctx context.Context, tx *sql.Tx, linkCommandID int, replacements map[string]string, pathMap pathMap, tmpDir, fileName string) (importcfgFileName string, replacedFiles map[string]string, err error) {
+	for packageName, file := range replacements {
+		if _, err := os.Stat(file); err != nil {
+			return "", nil, fmt.Errorf("invalid replacement for package %q: %w", packageName, err)
+		}
+	}
+
+	var importcfgFile *os.File
+	if fileName == "" {
+		importcfgFile, err = os.CreateTemp(tmpDir, "importcfg.link")
+	} else {
+		importcfgFile, err = os.Create(fileName)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to create importcfg file: %w", err)
+	}
+	defer func() {
+		if err2 := importcfgFile.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close importcfg file: %w", err2))
+		}
+		if err != nil {
+			if err2 := os.Remove(importcfgFile.Name()); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to remove importcfg file: %w", err2))
+			}
+		}
+	}()
+	importcfgFileName = importcfgFile.Name()
+
+	// The package files are sorted by package and followed by the additional
+	// lines in their captured order.
+	rows, err := tx.QueryContext(ctx, `
+SELECT package, file, NULL, NULL AS pos
+FROM package_file
+NATURAL JOIN link_command_package_file
+WHERE link_command_id = ?
+UNION ALL
+SELECT NULL, NULL, line, pos
+FROM importcfg_additional_lines
+NATURAL JOIN importcfg_line
+WHERE link_command_id = ?
+ORDER BY pos, package;`,
+		linkCommandID, linkCommandID)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to query importcfg: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close importcfg rows: %w", err2))
+		}
+	}()
+
+	replacedFiles = make(map[string]string)
+	var replacedPackages []string
+	for rows.Next() {
+		var packageName, file, line sql.NullString
+		var pos sql.NullInt64
+		if err := rows.Scan(&packageName, &file, &line, &pos); err != nil {
+			return "", nil, fmt.Errorf("unable to scan importcfg line: %w", err)
+		}
+		if !line.Valid {
+			if file.String, err = pathMap.expand(ctx, file.String); err != nil {
+				return "", nil, fmt.Errorf("unable to expand object file path of package %s: %w", packageName.String, err)
+			}
+			if replacement, ok := replacements[packageName.String]; ok {
+				logger.Info("Replacing package file", "package", packageName.String, "file", file.String, "replacement", replacement)
+				replacedFiles[file.String] = replacement
+				replacedPackages = append(replacedPackages, packageName.String)
+				file.String = replacement
+			}
+			line.String = "packagefile " + packageName.String + "=" + file.String
+		}
+		logger.Debug("Importcfg line", "file", importcfgFile.Name(), "line", line.String)
+		if _, err := fmt.Fprintln(importcfgFile, line.String); err != nil {
+			return "", nil, fmt.Errorf("unable to write importcfg line: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("error reading importcfg rows: %w", err)
+	}
+
+	for packageName := range replacements {
+		if !slices.Contains(replacedPackages, packageName) {
+			return "", nil, fmt.Errorf("package %q is not part of the link command", packageName)
+		}
+	}
+
+	return
+}
+
+// getLinkerCommandArgs rebuilds the argv of the link command.
+// extraArgs are inserted right before the main package positional argument.
+func getLinkerCommandArgs(ctx context.Context, tx *sql.Tx, linkCommandID int, mainPackage, binaryFileName, importcfgFileName string, extraArgs []string) ([]string, error) {
+	var argsJSON string
+	row := tx.QueryRowContext(ctx, `SELECT json(args) FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&argsJSON); err != nil {
+		return nil, fmt.Errorf("unable to query link command args: %w", err)
+	}
+	var storedArgs []string
+	if err := json.Unmarshal([]byte(argsJSON), &storedArgs); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal link command args: %w", err)
+	}
+
+	args := make([]string, 0, len(storedArgs)+len(extraArgs))
+	var prevArg string
+	for _, arg := range storedArgs {
+		switch {
+		case arg == "PLACEHOLDER" && prevArg == "-o":
+			arg = binaryFileName
+		case arg == "PLACEHOLDER" && prevArg == "-importcfg":
+			arg = importcfgFileName
+		case arg == "-o=PLACEHOLDER":
+			arg = "-o=" + binaryFileName
+		case arg == "-importcfg=PLACEHOLDER":
+			arg = "-importcfg=" + importcfgFileName
+		}
+
+		if arg == "MAIN PACKAGE" {
+			args = append(args, extraArgs...)
+			arg = mainPackage
+		}
+
+		args = append(args, arg)
+		prevArg = arg
+	}
+
+	return args, nil
+}
+
+// replaceExtld replaces the external linker set by the link command arguments.
+// The arguments are left untouched if the link command doesn’t set any, as
+// adding one would change the relinked binary.
+func replaceExtld(args []string, extld string) []string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-extld" && i+1 < len(args):
+			i++
+			args[i] = extld
+		case strings.HasPrefix(args[i], "-extld="):
+			args[i] = "-extld=" + extld
+		}
+	}
+
+	return args
+}
+
+// hasBuildID reports whether the link command arguments set a build ID.
+func hasBuildID(args []string) bool {
+	return slices.ContainsFunc(args, func(arg string) bool {
+		return arg == "-buildid" || strings.HasPrefix(arg, "-buildid=")
+	})
+}
+
+// removeBuildID removes the build ID from the link command arguments.
+func removeBuildID(args []string) []string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-buildid" && i+1 < len(args):
+			return slices.Delete(args, i, i+2)
+		case strings.HasPrefix(args[i], "-buildid="):
+			return slices.Delete(args, i, i+1)
+		}
+	}
+
+	return args
+}
+
+// rewriteBuildID replaces the content ID part of the build ID of the binary by
+// the hash of its content, like `go build` does after linking. The build ID
+// would otherwise be the one of the binary the link command was captured from.
+func rewriteBuildID(ctx context.Context, binaryFileName string) error {
+	logger.Info("Rewriting build ID", "path", binaryFileName)
+	if out, err := exec.CommandContext(ctx, "go", "tool", "buildid", "-w", binaryFileName).CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to rewrite build ID: %w\n%s", err, out)
+	}
+
+	return nil
+}
+
+// codesign signs the binary with the identity, `-` for an ad-hoc signature.
+// Rewriting the build ID invalidates the signature written by the linker, and
+// macOS kills the unsigned binaries of arm64 when they are executed.
+func codesign(ctx context.Context, identity, binaryFileName string) error {
+	logger.Info("Signing binary", "path", binaryFileName, "identity", identity)
+	if out, err := exec.CommandContext(ctx, "codesign", "--force", "--sign", identity, binaryFileName).CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to sign binary: %w\n%s", err, out)
+	}
+
+	return nil
+}
+
+// printLinkCommand writes the linker invocation and the content of its
+// importcfg file in a human readable form.
+func printLinkCommand(w io.Writer, linker string, args []string, importcfgFileName string) error {
+	importcfg, err := os.ReadFile(importcfgFileName)
+	if err != nil {
+		return fmt.Errorf("unable to read importcfg file: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Linker: %s\n", linker)
+	fmt.Fprintln(&buf, "Arguments:")
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "\t%q\n", arg)
+	}
+	fmt.Fprintf(&buf, "Importcfg %s:\n", importcfgFileName)
+	for _, line := range strings.Split(strings.TrimSuffix(string(importcfg), "\n"), "\n") {
+		fmt.Fprintf(&buf, "\t%s\n", line)
+	}
+
+	if _, err := buf.WriteTo(w); err != nil {
+		return fmt.Errorf("unable to write link command: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckLinker(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "link.exe")
+	if err := os.WriteFile(executable, nil, 0o755); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	notExecutable := filepath.Join(dir, "link")
+	if err := os.WriteFile(notExecutable, nil, 0o644); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		linker  string
+		wantErr string
+	}{
+		{
+			name:   "executable",
+			linker: executable,
+		},
+		{
+			name:    "missing",
+			linker:  filepath.Join(dir, "missing"),
+			wantErr: "unable to use linker",
+		},
+		{
+			name:    "directory",
+			linker:  dir,
+			wantErr: "is a directory",
+		},
+		{
+			name:   "not executable",
+			linker: notExecutable,
+		},
+	}
+	// Windows has no executable permission bits
+	if runtime.GOOS != "windows" {
+		tests[len(tests)-1].wantErr = "not executable"
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLinker(tt.linker)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkLinker() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkLinker() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplaceExtld(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "flag with value",
+			args: []string{"-o", "a.out", "-extld=gcc", "_pkg_.a"},
+			want: []string{"-o", "a.out", "-extld=/opt/cc", "_pkg_.a"},
+		},
+		{
+			name: "separate value",
+			args: []string{"-o", "a.out", "-extld", "gcc", "_pkg_.a"},
+			want: []string{"-o", "a.out", "-extld", "/opt/cc", "_pkg_.a"},
+		},
+		{
+			name: "none",
+			args: []string{"-o", "a.out", "_pkg_.a"},
+			want: []string{"-o", "a.out", "_pkg_.a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceExtld(slices.Clone(tt.args), "/opt/cc"); !slices.Equal(got, tt.want) {
+				t.Errorf("replaceExtld() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+// linkCommandInfo describes a link command stored in the database.
+type linkCommandInfo struct {
+	BinaryName      string   `json:"binary_name"`
+	BuildTags       []string `json:"build_tags"`
+	GOOS            string   `json:"goos"`
+	GOARCH          string   `json:"goarch"`
+	BuildMode       string   `json:"buildmode"`
+	Instrumentation string   `json:"instrumentation"` // Like `race`, empty if not instrumented
+	CgoEnabled      string   `json:"cgo_enabled"`     // Empty if unknown
+	BuildFlags      []string `json:"build_flags"`
+	PackageFiles    int      `json:"package_files"`
+	CreatedAt       string   `json:"created_at"` // Time first stored at, empty if unknown
+	UpdatedAt       string   `json:"updated_at"` // Time last stored at, empty if unknown
+}
+
+// noLinkCommandError is returned when no link command matches the binary, its
+// build tags, its target platform and its instrumentation.
+type noLinkCommandError struct {
+	BinaryName      string
+	BuildTags       []string
+	GOOS            string
+	GOARCH          string
+	Instrumentation string
+}
+
+func (e *noLinkCommandError) Error() string {
+	msg := fmt.Sprintf("no link command found for %q with build tags %q on %s/%s", e.BinaryName, e.BuildTags, e.GOOS, e.GOARCH)
+	if e.Instrumentation != "" {
+		msg += " with " + e.Instrumentation + " instrumentation"
+	}
+	return msg
+}
+
+// linkCommandVariant identifies one of the link commands of a binary.
+type linkCommandVariant struct {
+	BuildTags       []string `json:"build_tags"`
+	GOOS            string   `json:"goos"`
+	GOARCH          string   `json:"goarch"`
+	Instrumentation string   `json:"instrumentation"`
+}
+
+// reportNoLinkCommand writes notFound as a JSON object along with the link
+// commands stored for the same binary with other build tags or platforms.
+func reportNoLinkCommand(ctx context.Context, tx *sql.Tx, w io.Writer, notFound *noLinkCommandError) (err error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT json(tags), goos, goarch, instrumentation
+FROM link_command
+NATURAL JOIN build_tags
+WHERE binary_name = ?
+ORDER BY json(tags), goos, goarch, instrumentation;`,
+		notFound.BinaryName)
+	if err != nil {
+		return fmt.Errorf("unable to query link commands: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close link commands rows: %w", err2))
+		}
+	}()
+
+	alternatives := []linkCommandVariant{}
+	for rows.Next() {
+		var variant linkCommandVariant
+		var buildTagsJSON string
+		if err := rows.Scan(&buildTagsJSON, &variant.GOOS, &variant.GOARCH, &variant.Instrumentation); err != nil {
+			return fmt.Errorf("unable to scan link command: %w", err)
+		}
+		if err := json.Unmarshal([]byte(buildTagsJSON), &variant.BuildTags); err != nil {
+			return fmt.Errorf("unable to unmarshal build tags: %w", err)
+		}
+		if variant.BuildTags == nil {
+			variant.BuildTags = []string{}
+		}
+		alternatives = append(alternatives, variant)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading link commands rows: %w", err)
+	}
+
+	buildTags := notFound.BuildTags
+	if buildTags == nil {
+		buildTags = []string{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(struct {
+		Error           string               `json:"error"`
+		BinaryName      string               `json:"binary_name"`
+		BuildTags       []string             `json:"build_tags"`
+		GOOS            string               `json:"goos"`
+		GOARCH          string               `json:"goarch"`
+		Instrumentation string               `json:"instrumentation"`
+		Alternatives    []linkCommandVariant `json:"alternatives"`
+	}{notFound.Error(), notFound.BinaryName, buildTags, notFound.GOOS, notFound.GOARCH, notFound.Instrumentation, alternatives}); err != nil {
+		return fmt.Errorf("unable to encode error: %w", err)
+	}
+
+	return nil
+}
+
+// listLinkCommands writes the link commands stored in the database either as
+// a table or as a JSON array.
+func listLinkCommands(ctx context.Context, tx *sql.Tx, w io.Writer, asJSON bool) (err error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT binary_name, json(tags), goos, goarch, buildmode, instrumentation, coalesce(cgo_enabled, ''), coalesce(json(build_flags), '[]'), (
+	SELECT count(*)
+	FROM link_command_package_file
+	WHERE link_command_package_file.link_command_id = link_command.link_command_id
+), coalesce(created_at, ''), coalesce(updated_at, '')
+FROM link_command
+NATURAL JOIN build_tags
+ORDER BY binary_name, json(tags), goos, goarch, instrumentation;`)
+	if err != nil {
+		return fmt.Errorf("unable to query link commands: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close link commands rows: %w", err2))
+		}
+	}()
+
+	infos := []linkCommandInfo{}
+	for rows.Next() {
+		var info linkCommandInfo
+		var buildTagsJSON, buildFlagsJSON string
+		if err := rows.Scan(&info.BinaryName, &buildTagsJSON, &info.GOOS, &info.GOARCH, &info.BuildMode, &info.Instrumentation, &info.CgoEnabled, &buildFlagsJSON, &info.PackageFiles, &info.CreatedAt, &info.UpdatedAt); err != nil {
+			return fmt.Errorf("unable to scan link command: %w", err)
+		}
+		if err := json.Unmarshal([]byte(buildTagsJSON), &info.BuildTags); err != nil {
+			return fmt.Errorf("unable to unmarshal build tags: %w", err)
+		}
+		if info.BuildTags == nil {
+			info.BuildTags = []string{}
+		}
+		if err := json.Unmarshal([]byte(buildFlagsJSON), &info.BuildFlags); err != nil {
+			return fmt.Errorf("unable to unmarshal build flags: %w", err)
+		}
+		if info.BuildFlags == nil {
+			info.BuildFlags = []string{}
+		}
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading link commands rows: %w", err)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(infos); err != nil {
+			return fmt.Errorf("unable to encode link commands: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BINARY\tTAGS\tPLATFORM\tBUILDMODE\tPACKAGES\tCREATED\tUPDATED\tFLAGS")
+	for _, info := range infos {
+		// Flags are quoted as their values often contain spaces
+		buildFlags := make([]string, len(info.BuildFlags))
+		for i, flag := range info.BuildFlags {
+			buildFlags[i] = strconv.Quote(flag)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%d\t%s\t%s\t%s\n", info.BinaryName, strings.Join(info.BuildTags, ","), info.GOOS, info.GOARCH, info.BuildMode, info.PackageFiles,
+			cmp.Or(info.CreatedAt, "-"), cmp.Or(info.UpdatedAt, "-"), strings.Join(buildFlags, " "))
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write link commands: %w", err)
+	}
+
+	return nil
+}
+
+// matchBinaryName returns the name of the single binary stored with the build
+// tags, target platform and instrumentation of config whose name matches
+// config.match.
+func matchBinaryName(ctx context.Context, tx *sql.Tx, config Config) (binaryName string, err error) {
+	// With -select-latest, the binary stored last comes first
+	rows, err := tx.QueryContext(ctx, `
+SELECT binary_name
+FROM link_command
+NATURAL JOIN build_tags
+WHERE (? OR canonical = ?) AND (? OR goos = ?) AND (? OR goarch = ?) AND (? OR instrumentation = ?)
+GROUP BY binary_name
+ORDER BY CASE WHEN ? THEN NULL ELSE binary_name END, max(updated_at) IS NULL, max(updated_at) DESC, max(link_command_id) DESC;`,
+		config.anyVariant.tags, interceptor.CanonicalBuildTags(config.buildTags), config.anyVariant.goos, config.goos,
+		config.anyVariant.goarch, config.goarch, config.anyVariant.instrumentation, config.instrumentation, config.selectLatest)
+	if err != nil {
+		return "", fmt.Errorf("unable to query binary names: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close binary names rows: %w", err2))
+		}
+	}()
+
+	var candidates []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", fmt.Errorf("unable to scan binary name: %w", err)
+		}
+		if config.match.MatchString(name) {
+			candidates = append(candidates, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error reading binary names rows: %w", err)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no binary stored with build tags %q on %s/%s matches %q", config.buildTags, config.goos, config.goarch, config.match)
+	case 1:
+		return candidates[0], nil
+	default:
+		if config.selectLatest {
+			logger.Info("Selected latest binary", "binary", candidates[0], "candidates", candidates)
+			return candidates[0], nil
+		}
+		return "", fmt.Errorf("%d binaries match %q, make it more specific:\n\t%s", len(candidates), config.match, strings.Join(candidates, "\n\t"))
+	}
+}
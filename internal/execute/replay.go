@@ -0,0 +1,195 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+// ReplayOptions control how Replay relinks a binary.
+type ReplayOptions struct {
+	Linker          string   // Path of the linker, the one in `go env GOTOOLDIR` if empty
+	GOOS            string   // Target operating system of the binary, runtime.GOOS if empty
+	GOARCH          string   // Target architecture of the binary, runtime.GOARCH if empty
+	Instrumentation []string // Like `race` for a link command captured with -race
+	// Output is the path the binary is written to. Without it, the binary is
+	// linked into a temporary file. That file is left behind in TempDir when
+	// the binary replaces the current process, as on Unix.
+	Output string
+	// Binary receives the content of the linked binary when it isn’t nil.
+	Binary io.Writer
+	// TempDir is the directory of the temporary files, like the importcfg,
+	// the default one of the OS if empty.
+	TempDir string
+	// Replacements are the object files used instead of those of packages,
+	// keyed by package.
+	Replacements map[string]string
+	// Exec executes the binary with Args once it is linked.
+	//
+	// WARNING: on Unix, the binary replaces the current process, so Replay
+	// never returns and the process embedding it is gone, along with its
+	// deferred functions and unflushed output. Elsewhere, the binary is
+	// spawned and Replay returns an *ExitError when it fails.
+	Exec bool
+	Args []string
+	// CodesignIdentity is the identity the binary is signed with before it
+	// runs, `-` for an ad-hoc signature, which macOS requires. The binary
+	// isn’t signed when it’s empty.
+	CodesignIdentity string
+	// Stdout and Stderr receive the output of the linker. It is discarded
+	// when they are nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Replay relinks the binary stored in db under the name binary and the build
+// tags, like the executor does. It doesn’t return once the binary replaced the
+// current process.
+func Replay(ctx context.Context, db *sql.DB, binary string, tags []string, opts ReplayOptions) (err error) {
+	if opts.Output == "" && opts.Binary == nil && !opts.Exec {
+		return errors.New("the binary must be written to an output path or a writer, or executed")
+	}
+
+	config := Config{
+		binaryName:      binary,
+		buildTags:       interceptor.ParseBuildTags(strings.Join(tags, ",")),
+		goos:            cmp.Or(opts.GOOS, runtime.GOOS),
+		goarch:          cmp.Or(opts.GOARCH, runtime.GOARCH),
+		instrumentation: strings.Join(slices.Sorted(slices.Values(opts.Instrumentation)), ","),
+		replacements:    opts.Replacements,
+		tmpDir:          opts.TempDir,
+	}
+
+	linker := opts.Linker
+	if linker == "" {
+		if linker, err = interceptor.Linker(ctx); err != nil {
+			return fmt.Errorf("unable to find the linker: %w", err)
+		}
+	}
+	if err := checkLinker(linker); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	linkCommandID, mainPackage, err := getLinkCommandID(ctx, tx, config)
+	if err != nil {
+		return fmt.Errorf("unable to get link command ID: %w", err)
+	}
+	buildMode, err := checkBuildMode(ctx, tx, linkCommandID, "")
+	if err != nil {
+		return err
+	}
+	if opts.Exec && !isExecutable(buildMode) {
+		return fmt.Errorf("%s is linked with -buildmode=%s and can’t be executed", binary, buildMode)
+	}
+	if err := checkWorkDir(ctx, tx, linkCommandID); err != nil {
+		return err
+	}
+
+	importcfgFileName, replacedFiles, err := getImportcfg(ctx, tx, linkCommandID, config.replacements, nil, config.tmpDir, "")
+	if err != nil {
+		return fmt.Errorf("unable to get importcfg: %w", err)
+	}
+	defer func() {
+		if err2 := os.Remove(importcfgFileName); err2 != nil && !os.IsNotExist(err2) {
+			err = errors.Join(err, fmt.Errorf("unable to remove importcfg file: %w", err2))
+		}
+	}()
+	if file, ok := replacedFiles[mainPackage]; ok {
+		mainPackage = file
+	}
+
+	binaryFileName := opts.Output
+	if binaryFileName == "" {
+		binaryFile, err := os.CreateTemp(config.tmpDir, filepath.Base(binary))
+		if err != nil {
+			return fmt.Errorf("unable to create binary file: %w", err)
+		}
+		binaryFileName = binaryFile.Name()
+		// The binary is only kept when it replaces the current process
+		defer func() {
+			if err2 := os.Remove(binaryFileName); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to remove binary file: %w", err2))
+			}
+		}()
+		if err := binaryFile.Close(); err != nil {
+			return fmt.Errorf("unable to close binary file: %w", err)
+		}
+	}
+
+	args, err := getLinkerCommandArgs(ctx, tx, linkCommandID, mainPackage, binaryFileName, importcfgFileName, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get link command args: %w", err)
+	}
+
+	logger.Info("Link command", "link_command_id", linkCommandID, "linker", linker, "args", args)
+	linkCmd := linkerCommand(ctx, linker, args)
+	linkCmd.Stdout = opts.Stdout
+	linkCmd.Stderr = opts.Stderr
+	if err := linkCmd.Run(); err != nil {
+		return fmt.Errorf("linker command failed: %w", err)
+	}
+	if hasBuildID(args) {
+		if err := rewriteBuildID(ctx, binaryFileName); err != nil {
+			return err
+		}
+	}
+
+	// The file created by os.CreateTemp isn’t executable
+	if err := os.Chmod(binaryFileName, 0o755); err != nil { //nolint:gosec
+		return fmt.Errorf("unable to make binary executable: %w", err)
+	}
+	if opts.CodesignIdentity != "" && isExecutable(buildMode) {
+		if err := codesign(ctx, opts.CodesignIdentity, binaryFileName); err != nil {
+			return err
+		}
+	}
+	if opts.Binary != nil {
+		if err := copyBinary(opts.Binary, binaryFileName); err != nil {
+			return err
+		}
+	}
+	if !opts.Exec {
+		return nil
+	}
+
+	// Deferred functions don’t run when the binary replaces the current process
+	if err := os.Remove(importcfgFileName); err != nil {
+		return fmt.Errorf("unable to remove importcfg file: %w", err)
+	}
+	return newExecRunner(func() {}).run(ctx, binaryFileName, append([]string{binary}, opts.Args...))
+}
+
+// copyBinary writes the content of the binary file to w.
+func copyBinary(w io.Writer, binaryFileName string) error {
+	binaryFile, err := os.Open(binaryFileName)
+	if err != nil {
+		return fmt.Errorf("unable to open binary file: %w", err)
+	}
+	defer binaryFile.Close()
+
+	if _, err := io.Copy(w, binaryFile); err != nil {
+		return fmt.Errorf("unable to write binary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+)
+
+// binaryRunner runs the linked binary with the arguments argv, argv[0] being
+// the name it is executed as.
+type binaryRunner interface {
+	run(ctx context.Context, binaryFileName string, argv []string) error
+}
+
+// spawnRunner runs the binary as a child process, and returns a cli.ExitError
+// with its status when it fails.
+type spawnRunner struct{}
+
+func (spawnRunner) run(ctx context.Context, binaryFileName string, argv []string) error {
+	logger.Info("Spawn", "binary", binaryFileName, "args", argv[1:])
+	cmd := exec.CommandContext(ctx, binaryFileName, argv[1:]...) //nolint:gosec
+	cmd.Args[0] = argv[0]
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = func() error {
+		// Interrupts can’t be sent on Windows
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	if cmd.ProcessState != nil {
+		logger.Info("Binary exited", "exit_code", cmd.ProcessState.ExitCode(), "duration", time.Since(start))
+	}
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok && err.Exited() {
+			return &cli.ExitError{Code: err.ExitCode()}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("binary interrupted: %w", context.Cause(ctx))
+		}
+		return fmt.Errorf("unable to run binary: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+//go:build !unix
+
+package execute
+
+import "context"
+
+// newExecRunner spawns the binary, as a process can’t be replaced by another on
+// Windows and the other platforms without exec. The executor then exits with
+// the status of the binary.
+func newExecRunner(context.CancelFunc) binaryRunner {
+	return spawnRunner{}
+}
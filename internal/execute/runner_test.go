@@ -0,0 +1,99 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+)
+
+// The test binary stands for the binary run by the runners when these
+// environment variables are set: it writes its arguments to the file and exits
+// with the status.
+const (
+	helperArgsEnvVar   = "GOLINK_TEST_HELPER_ARGS"
+	helperStatusEnvVar = "GOLINK_TEST_HELPER_STATUS"
+)
+
+func TestMain(m *testing.M) {
+	if argsFileName := os.Getenv(helperArgsEnvVar); argsFileName != "" {
+		data, err := json.Marshal(os.Args)
+		if err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(argsFileName, data, 0o600); err != nil {
+			panic(err)
+		}
+		status, _ := strconv.Atoi(os.Getenv(helperStatusEnvVar))
+		os.Exit(status)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestSpawnRunner(t *testing.T) {
+	tests := []struct {
+		name     string
+		argv     []string
+		status   int
+		wantCode int // 0 if no *cli.ExitError is expected
+	}{
+		{
+			name: "success",
+			argv: []string{"hello", "-v", "world with spaces"},
+		},
+		{
+			name:     "failure",
+			argv:     []string{"hello"},
+			status:   3,
+			wantCode: 3,
+		},
+	}
+
+	binaryFileName, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argsFileName := filepath.Join(t.TempDir(), "args.json")
+			t.Setenv(helperArgsEnvVar, argsFileName)
+			t.Setenv(helperStatusEnvVar, strconv.Itoa(tt.status))
+
+			var runner binaryRunner = spawnRunner{}
+			err := runner.run(context.Background(), binaryFileName, tt.argv)
+			var exitErr *cli.ExitError
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("run() error = %v", err)
+			case tt.wantCode != 0 && !errors.As(err, &exitErr):
+				t.Fatalf("run() error = %v, want *cli.ExitError", err)
+			case tt.wantCode != 0 && exitErr.Code != tt.wantCode:
+				t.Errorf("exit code = %d, want %d", exitErr.Code, tt.wantCode)
+			}
+
+			data, err := os.ReadFile(argsFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.argv) {
+				t.Errorf("argv = %q, want %q", got, tt.argv)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+//go:build unix
+
+package execute
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// execRunner executes the binary in place of the executor.
+type execRunner struct {
+	stop context.CancelFunc // Restores the default handling of interrupts
+}
+
+func newExecRunner(stop context.CancelFunc) binaryRunner {
+	return execRunner{stop: stop}
+}
+
+func (r execRunner) run(_ context.Context, binaryFileName string, argv []string) error {
+	r.stop()
+	logger.Info("Exec", "binary", binaryFileName, "args", argv[1:])
+	if err := syscall.Exec(binaryFileName, argv, os.Environ()); err != nil { //nolint:gosec
+		return fmt.Errorf("exec failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// dbStats summarizes the content of the database and how much the package
+// files are shared between the link commands.
+type dbStats struct {
+	LinkCommands int `json:"link_commands"`
+	PackageFiles int `json:"package_files"`
+	// References is the number of package files of all the link commands,
+	// which would be stored without sharing
+	References int `json:"references"`
+	// LinkCommandsPerPackageFile is the average number of link commands
+	// referencing a package file, 1 means nothing is shared
+	LinkCommandsPerPackageFile float64 `json:"link_commands_per_package_file"`
+	DBSize                     int64   `json:"db_size"` // In bytes, including the WAL
+}
+
+// printStats writes the statistics of the database either as a table or as a
+// JSON object.
+func printStats(ctx context.Context, tx *sql.Tx, dbPath string, w io.Writer, asJSON bool) error {
+	var stats dbStats
+	row := tx.QueryRowContext(ctx, `
+SELECT
+	(SELECT count(*) FROM link_command),
+	(SELECT count(*) FROM package_file),
+	(SELECT count(*) FROM link_command_package_file),
+	(SELECT coalesce(avg(references_count), 0) FROM (
+		SELECT count(*) AS references_count
+		FROM link_command_package_file
+		GROUP BY package_file_id
+	));`)
+	if err := row.Scan(&stats.LinkCommands, &stats.PackageFiles, &stats.References, &stats.LinkCommandsPerPackageFile); err != nil {
+		return fmt.Errorf("unable to query statistics: %w", err)
+	}
+
+	for _, fileName := range []string{dbPath, dbPath + "-wal"} {
+		fi, err := os.Stat(fileName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("unable to get size of %s: %w", fileName, err)
+		}
+		stats.DBSize += fi.Size()
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			return fmt.Errorf("unable to encode statistics: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Link commands:\t%d\n", stats.LinkCommands)
+	fmt.Fprintf(tw, "Package files:\t%d\n", stats.PackageFiles)
+	fmt.Fprintf(tw, "Package file references:\t%d\n", stats.References)
+	fmt.Fprintf(tw, "Link commands per package file:\t%.2f\n", stats.LinkCommandsPerPackageFile)
+	fmt.Fprintf(tw, "DB size:\t%d bytes\n", stats.DBSize)
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write statistics: %w", err)
+	}
+
+	return nil
+}
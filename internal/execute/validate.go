@@ -0,0 +1,93 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// validationQueries select a description of each anomaly of the database.
+var validationQueries = []struct {
+	name  string
+	query string
+}{
+	{"integrity", `
+SELECT integrity_check
+FROM pragma_integrity_check
+WHERE integrity_check != 'ok';`},
+	{"main package", `
+SELECT 'link command ' || link_command_id || ' of ' || binary_name || ' has no main package'
+FROM link_command
+WHERE main_package_id IS NULL
+UNION ALL
+SELECT 'link command ' || link_command_id || ' of ' || binary_name || ' references the missing main package file ' || main_package_id
+FROM link_command
+WHERE main_package_id IS NOT NULL
+	AND main_package_id NOT IN (SELECT package_file_id FROM package_file);`},
+	{"package files", `
+SELECT 'link command ' || link_command_id || ' references the missing package file ' || package_file_id
+FROM link_command_package_file
+WHERE package_file_id NOT IN (SELECT package_file_id FROM package_file)
+UNION ALL
+SELECT 'package file ' || package_file_id || ' is referenced by the missing link command ' || link_command_id
+FROM link_command_package_file
+WHERE link_command_id NOT IN (SELECT link_command_id FROM link_command);`},
+	{"importcfg lines", `
+SELECT 'link command ' || link_command_id || ' references the missing importcfg line ' || importcfg_line_id
+FROM importcfg_additional_lines
+WHERE importcfg_line_id NOT IN (SELECT importcfg_line_id FROM importcfg_line);`},
+	{"arguments", `
+SELECT 'link command ' || link_command_id || ' of ' || binary_name || ' has no arguments'
+FROM link_command
+WHERE args IS NULL OR json_array_length(args) = 0;`},
+}
+
+// validateDB writes the anomalies of the database, like link commands left
+// partially written, and returns how many were found.
+func validateDB(ctx context.Context, tx *sql.Tx, w io.Writer) (problems int, err error) {
+	for _, q := range validationQueries {
+		n, err := writeAnomalies(ctx, tx, q.query, w)
+		if err != nil {
+			return 0, fmt.Errorf("unable to check %s: %w", q.name, err)
+		}
+		problems += n
+	}
+
+	return problems, nil
+}
+
+// writeAnomalies writes the descriptions selected by query, one per line.
+func writeAnomalies(ctx context.Context, tx *sql.Tx, query string, w io.Writer) (n int, err error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("unable to query anomalies: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close anomalies rows: %w", err2))
+		}
+	}()
+
+	for rows.Next() {
+		var anomaly string
+		if err := rows.Scan(&anomaly); err != nil {
+			return 0, fmt.Errorf("unable to scan anomaly: %w", err)
+		}
+		if _, err := fmt.Fprintln(w, anomaly); err != nil {
+			return 0, fmt.Errorf("unable to write anomaly: %w", err)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading anomalies rows: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,92 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package execute
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// verifyBinary builds pkg with `go build` the way the link command was
+// captured and compares the result byte for byte with the relinked binary.
+//
+// The binaries are expected to be identical as Go binaries don’t embed
+// timestamps and the build ID of the relinked binary is recomputed. They
+// differ nonetheless when:
+//   - the binary is relinked with -no-buildid, -X, -extld or -replace, or
+//     from package files rewritten by -path-map;
+//   - the sources, the dependencies or the toolchain changed since the
+//     capture, including the VCS information stamped into the binary, like
+//     vcs.modified and vcs.time;
+//   - the link command was captured without -trimpath from another
+//     directory, as the DWARF information holds the absolute source paths;
+//   - the binary is linked by an external linker that isn’t deterministic.
+func verifyBinary(ctx context.Context, tx *sql.Tx, linkCommandID int, config Config, pkg, relinkedFileName string, w io.Writer) (err error) {
+	var buildFlagsJSON sql.NullString
+	row := tx.QueryRowContext(ctx, `SELECT json(build_flags) FROM link_command WHERE link_command_id = ?;`, linkCommandID)
+	if err := row.Scan(&buildFlagsJSON); err != nil {
+		return fmt.Errorf("unable to query build flags: %w", err)
+	}
+	var buildFlags []string
+	if buildFlagsJSON.Valid {
+		if err := json.Unmarshal([]byte(buildFlagsJSON.String), &buildFlags); err != nil {
+			return fmt.Errorf("unable to unmarshal build flags: %w", err)
+		}
+	}
+
+	freshFile, err := os.CreateTemp(config.tmpDir, "fresh")
+	if err != nil {
+		return fmt.Errorf("unable to create fresh binary file: %w", err)
+	}
+	defer func() {
+		if err2 := os.Remove(freshFile.Name()); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to remove fresh binary file: %w", err2))
+		}
+	}()
+	if err := freshFile.Close(); err != nil {
+		return fmt.Errorf("unable to close fresh binary file: %w", err)
+	}
+
+	args := []string{"build", "-o", freshFile.Name(), "-tags=" + strings.Join(config.buildTags, ",")}
+	if config.modMode != "" {
+		args = append(args, "-mod="+config.modMode)
+	}
+	args = append(append(args, buildFlags...), pkg)
+	logger.Info("Fresh build", "args", args)
+	buildCmd := exec.CommandContext(ctx, "go", args...)
+	buildCmd.Env = append(os.Environ(), "GOOS="+config.goos, "GOARCH="+config.goarch)
+	if out, err := buildCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to build %s: %w\n%s", pkg, err, out)
+	}
+
+	relinked, err := os.ReadFile(relinkedFileName)
+	if err != nil {
+		return fmt.Errorf("unable to read relinked binary: %w", err)
+	}
+	fresh, err := os.ReadFile(freshFile.Name())
+	if err != nil {
+		return fmt.Errorf("unable to read fresh binary: %w", err)
+	}
+
+	if len(relinked) != len(fresh) {
+		return fmt.Errorf("relinked binary is %d bytes long but a fresh build of %s is %d bytes long", len(relinked), pkg, len(fresh))
+	}
+	for i := range relinked {
+		if relinked[i] != fresh[i] {
+			return fmt.Errorf("relinked binary differs from a fresh build of %s at offset %#x", pkg, i)
+		}
+	}
+
+	fmt.Fprintf(w, "Relinked binary is identical to a fresh build of %s\n", pkg)
+	return nil
+}
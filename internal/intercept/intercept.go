@@ -0,0 +1,735 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package intercept implements the interceptor, which stores the link commands
+// of a Go build.
+package intercept
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+var logger = slog.Default()
+
+// defaultBuildAttempts is the default number of times the program is built
+// until all the package files are in the Go build cache. Packages compiled by
+// a build are linked from its work directory, they are only linked from the
+// cache by the next build.
+const defaultBuildAttempts = 3
+
+// buildBackoff is the delay before the second build, doubled before each
+// following one.
+const buildBackoff = 100 * time.Millisecond
+
+// buildOutputTail is how many bytes at the end of the build output are
+// reported when the build fails.
+const buildOutputTail = 64 << 10
+
+// buildWaitDelay is how long an interrupted build is given to stop its
+// subprocesses and remove its work directory before being killed.
+const buildWaitDelay = 5 * time.Second
+
+// Run intercepts the build given by the command line arguments cmdLine.
+// The flags are registered on fs. The common options are parsed from cmdLine
+// as well when opts is nil.
+func Run(ctx context.Context, fs *flag.FlagSet, cmdLine []string, opts *cli.Options) (err error) {
+	config, err := parseConfig(ctx, fs, cmdLine, opts)
+	if err != nil {
+		return fmt.Errorf("unable to parse config: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, config.metrics.Write(os.Stderr))
+	}()
+
+	// The timeout covers both the builds and the database transaction
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+
+	if config.pruneBinary != "" || config.pruneOrphans {
+		if err := prune(ctx, config); err != nil {
+			return fmt.Errorf("unable to prune database: %w", err)
+		}
+		return nil
+	}
+
+	if config.importFile != "" || (config.exportFile != "" && len(config.args) == 0 && config.fromFile == "") {
+		if err := exportOrImport(ctx, config); err != nil {
+			return fmt.Errorf("unable to export or import database: %w", err)
+		}
+		return nil
+	}
+
+	// When the output is a directory, binaries already up to date in it
+	// wouldn’t be relinked. The programs are then built into an empty
+	// temporary directory and moved to the output directory afterwards.
+	outputIsDir := strings.HasSuffix(config.binaryName, "/") || strings.HasSuffix(config.binaryName, `\`)
+	if fi, err := os.Stat(config.outputPath()); err == nil && fi.IsDir() {
+		outputIsDir = true
+	}
+
+	// `go install` doesn’t reinstall binaries that are up to date
+	var installTargets []string
+	if config.install && config.fromFile == "" {
+		if installTargets, err = listInstallTargets(ctx, config.args, config.flagsArg); err != nil {
+			return fmt.Errorf("unable to list install targets: %w", err)
+		}
+	}
+
+	var result *interceptor.BuildResult
+	var buildDir string
+	defer func() {
+		if buildDir == "" {
+			return
+		}
+		if err2 := os.RemoveAll(buildDir); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to remove temporary output directory %s: %w", buildDir, err2))
+		}
+	}()
+	var uncachedFiles []string
+	if config.fromFile != "" {
+		stopParse := config.metrics.Track("parse")
+		if result, err = parseBuildLog(ctx, config); err != nil {
+			return err
+		}
+		stopParse()
+		// The build can’t be run again to put the package files in the cache
+		if !config.skipCacheCheck {
+			stopCacheCheck := config.metrics.Track("cache check")
+			uncachedFiles, err = interceptor.UncachedPackageFiles(ctx, result)
+			stopCacheCheck()
+			if err != nil {
+				return fmt.Errorf("unable to check if all files are in cache: %w", err)
+			}
+			if len(uncachedFiles) > 0 {
+				return fmt.Errorf("package files of the build output aren’t in the Go build cache, use -skip-cache-check to store the link commands anyway:\n\t%s", strings.Join(uncachedFiles, "\n\t"))
+			}
+		}
+	}
+	for attempt := 1; config.fromFile == "" && attempt <= config.buildAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(buildBackoff << (attempt - 2)):
+			case <-ctx.Done():
+				return fmt.Errorf("build interrupted: %w", context.Cause(ctx))
+			}
+		}
+		args := slices.Clone(config.args[1:])
+		if outputIsDir {
+			if err := os.MkdirAll(config.outputPath(), 0o755); err != nil {
+				return fmt.Errorf("unable to create output directory %s: %w", config.outputPath(), err)
+			}
+			if buildDir != "" {
+				if err := os.RemoveAll(buildDir); err != nil {
+					return fmt.Errorf("unable to remove temporary output directory %s: %w", buildDir, err)
+				}
+			}
+			buildDir, err = os.MkdirTemp(config.outputPath(), ".golinkinterceptor-")
+			if err != nil {
+				return fmt.Errorf("unable to create temporary output directory: %w", err)
+			}
+			// The go command resolves relative paths from the directory of -C
+			if buildDir, err = filepath.Abs(buildDir); err != nil {
+				return fmt.Errorf("unable to get absolute path of temporary output directory: %w", err)
+			}
+			// args doesn’t start with `go` contrary to config.args
+			if output := &args[config.outputArg-1]; strings.HasPrefix(*output, "-o=") {
+				*output = "-o=" + buildDir + "/"
+			} else {
+				*output = buildDir + "/"
+			}
+		} else if config.removeOutput {
+			// Force program rebuild
+			for _, binaryName := range append(installTargets, config.outputPath()) {
+				if binaryName == "" {
+					continue
+				}
+				err = os.Remove(binaryName)
+				if err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("unable to remove output file %s: %w", binaryName, err)
+				}
+			}
+		}
+
+		// Build the program
+		// The output is parsed as the build runs, so both are tracked at once
+		stopBuild := config.metrics.Track("build")
+		args = slices.Insert(args, config.flagsArg-1, "-x")
+		buildCmd := exec.CommandContext(ctx, config.args[0], args...) //nolint:gosec
+		// Like on Ctrl+C, the go command stops the compilers and removes its
+		// work directory when interrupted
+		buildCmd.Cancel = func() error {
+			// Interrupts can’t be sent on Windows
+			if err := buildCmd.Process.Signal(os.Interrupt); err != nil {
+				return buildCmd.Process.Kill()
+			}
+			return nil
+		}
+		buildCmd.WaitDelay = buildWaitDelay
+		// The output is parsed while the build runs instead of being held in
+		// memory. Only its end is kept to report a failed build.
+		outReader, outWriter := io.Pipe()
+		buildCmd.Stdout = outWriter
+		buildCmd.Stderr = outWriter
+		tail := &tailBuffer{size: buildOutputTail}
+		if err := buildCmd.Start(); err != nil {
+			return fmt.Errorf("unable to start build: %w", err)
+		}
+		buildErr := make(chan error, 1)
+		go func() {
+			err := buildCmd.Wait()
+			outWriter.Close()
+			buildErr <- err
+		}()
+
+		// Extract the link command from the `go build -x` output
+		var parseErr error
+		result, parseErr = interceptor.ParseBuildOutput(ctx, io.TeeReader(outReader, tail), config.maxLineLength)
+		// The build blocks until all its output is read
+		_, _ = io.Copy(io.Discard, outReader)
+		if err := <-buildErr; err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("build interrupted: %w", context.Cause(ctx))
+			}
+			return fmt.Errorf("unable to get link command: %w\n%s", err, tail.buf)
+		}
+		// The go command doesn’t relink binaries which are up to date
+		if errors.Is(parseErr, interceptor.ErrNoLinkCommand) && !outputIsDir {
+			for _, binaryName := range append(installTargets, config.outputPath()) {
+				if _, err := os.Stat(binaryName); binaryName != "" && err == nil {
+					return fmt.Errorf("%s is up to date and wasn’t relinked, it’s removed before the build unless -remove-output=false: %w", binaryName, parseErr)
+				}
+			}
+		}
+		if parseErr != nil {
+			return fmt.Errorf("unable to parse Go build output: %w", parseErr)
+		}
+		stopBuild()
+
+		stopCacheCheck := config.metrics.Track("cache check")
+		uncachedFiles, err = interceptor.UncachedPackageFiles(ctx, result)
+		stopCacheCheck()
+		if err != nil {
+			return fmt.Errorf("unable to check if all files are in cache: %w", err)
+		}
+		if len(uncachedFiles) == 0 {
+			break
+		}
+		logger.Info("Package files aren’t in the Go build cache yet", "attempt", attempt, "attempts", config.buildAttempts, "uncached_files", len(uncachedFiles))
+		logger.Debug("Uncached package files", "attempt", attempt, "files", uncachedFiles)
+	}
+	// The executor would link against files removed at the end of the build
+	if len(uncachedFiles) > 0 {
+		return fmt.Errorf("package files still not in the Go build cache after %d builds:\n\t%s", config.buildAttempts, strings.Join(uncachedFiles, "\n\t"))
+	}
+
+	// The work directory is removed at the end of the build
+	if args := interceptor.WorkDirArgs(result); len(args) > 0 {
+		return fmt.Errorf("link command arguments reference the work directory of the build, they can’t be relinked:\n\t%s", strings.Join(args, "\n\t"))
+	}
+
+	// The command line takes precedence over GOFLAGS
+	if config.hasBuildTags {
+		result.BuildTags = config.buildTags
+	}
+	result.BuildFlags = config.buildFlags
+	result.Instrumentation = config.instrumentation
+	if result.BuildDir, err = os.Getwd(); err != nil {
+		return fmt.Errorf("unable to get working directory: %w", err)
+	}
+	// Relative binary names are resolved from the directory of `-C`
+	if config.chdir != "" {
+		if filepath.IsAbs(config.chdir) {
+			result.BuildDir = filepath.Clean(config.chdir)
+		} else {
+			result.BuildDir = filepath.Join(result.BuildDir, config.chdir)
+		}
+	}
+	// The command line takes precedence over GOFLAGS
+	if modMode := interceptor.ModMode(config.args); modMode != "" {
+		result.ModMode = modMode
+	}
+	if len(result.LinkCommands) > 1 || outputIsDir || config.install || config.binaryName == "" {
+		for i, linkCommand := range result.LinkCommands {
+			if linkCommand.Output == "" {
+				return fmt.Errorf("unable to find the output binary of link command %q", strings.Join(linkCommand.Args, " "))
+			}
+			if outputIsDir {
+				binaryName := filepath.Join(config.binaryName, filepath.Base(linkCommand.Output))
+				// The binaries of a build output read from a file were
+				// written by the build that produced it
+				if config.fromFile == "" {
+					if err := os.Rename(linkCommand.Output, filepath.Join(config.outputPath(), filepath.Base(linkCommand.Output))); err != nil {
+						return fmt.Errorf("unable to move binary to output directory: %w", err)
+					}
+				}
+				linkCommand.Output = binaryName
+			}
+			result.LinkCommands[i].BinaryName = linkCommand.Output
+		}
+	} else {
+		for i := range result.LinkCommands {
+			result.LinkCommands[i].BinaryName = filepath.Clean(config.binaryName)
+		}
+	}
+
+	if config.mainPackage != "" {
+		for i := range result.LinkCommands {
+			result.LinkCommands[i].MainPackage = config.mainPackage
+		}
+	}
+
+	if len(config.only) > 0 {
+		linkCommands := result.LinkCommands[:0]
+		for _, linkCommand := range result.LinkCommands {
+			mainPackage := result.MainPackage(linkCommand)
+			if !config.only.matches(linkCommand.BinaryName, mainPackage) {
+				logger.Info("Skipping link command not matched by -only", "binary", linkCommand.BinaryName, "main_package", mainPackage)
+				continue
+			}
+			linkCommands = append(linkCommands, linkCommand)
+		}
+		if len(linkCommands) == 0 {
+			return fmt.Errorf("no link command matches the -only patterns %s", config.only.String())
+		}
+		result.LinkCommands = linkCommands
+	}
+
+	if config.relativeCache {
+		if err := interceptor.RelativizeCachePaths(ctx, result); err != nil {
+			return fmt.Errorf("unable to make package file paths relative to the Go build cache: %w", err)
+		}
+	}
+
+	if config.print {
+		if err := printLinkCommands(os.Stdout, result); err != nil {
+			return err
+		}
+	}
+	if config.noWrite {
+		return nil
+	}
+
+	stopStore := config.metrics.Track("store")
+	if err := writeToDB(ctx, config, result); err != nil {
+		return fmt.Errorf("unable to write to database: %w", err)
+	}
+	stopStore()
+
+	return nil
+}
+
+type Config struct {
+	dbPath          string
+	busyTimeout     time.Duration
+	timeout         time.Duration // No timeout if zero
+	args            []string
+	binaryName      string
+	outputArg       int    // Position in args of the `-o` flag value
+	chdir           string // Directory given to `-C`, which the output path is relative to
+	flagsArg        int    // Position in args of the first flag after `-C`, which must come first
+	install         bool   // Binaries are installed by `go install` instead of built
+	buildTags       []string
+	hasBuildTags    bool     // Build tags are set on the command line, overriding GOFLAGS
+	buildFlags      []string // Build flags recorded along the link commands
+	relativeCache   bool     // Package files are stored relative to GOCACHE
+	maxLineLength   int      // Maximum length of a line of the build output
+	buildAttempts   int      // Maximum number of builds until the package files are cached
+	removeOutput    bool     // Output binaries are removed to be relinked
+	fromFile        string   // File the build output is read from instead of running the build, `-` for stdin
+	skipCacheCheck  bool     // Link commands read from a file are stored even if not cached
+	force           bool     // Existing link commands are deleted instead of updated, resetting their ID and creation time
+	only            patternFlag
+	mainPackage     string   // Import path of the main package, detected if empty
+	print           bool     // Captured link commands are printed
+	noWrite         bool     // Captured link commands aren’t stored
+	instrumentation []string // Sorted instrumentations enabled by `-race`, `-msan` or `-asan`
+
+	pruneBinary  string
+	pruneTags    []string
+	pruneAnyTags bool
+	pruneOrphans bool
+
+	exportFile string
+	importFile string
+
+	metrics *cli.Metrics // Nil unless -metrics is given
+}
+
+func parseConfig(_ context.Context, fs *flag.FlagSet, cmdLine []string, opts *cli.Options) (config Config, err error) {
+	if opts == nil {
+		opts = &cli.Options{}
+		opts.AddFlags(fs)
+	}
+	fs.DurationVar(&config.busyTimeout, "busy-timeout", 5*time.Second, "How long to wait for the DB to be unlocked by concurrent interceptors")
+	fs.DurationVar(&config.timeout, "timeout", 0, "Give up if the builds and the DB update take longer than this duration (0 = no timeout)")
+	fs.StringVar(&config.fromFile, "from-file", "", "Read the output of `go build -x` from this file, or stdin if -, instead of running the build given on the command line")
+	fs.BoolVar(&config.skipCacheCheck, "skip-cache-check", false, "Store the link commands read with -from-file even if their package files aren’t in the Go build cache")
+	fs.IntVar(&config.buildAttempts, "build-attempts", defaultBuildAttempts, "Maximum number of builds until all the package files are in the Go build cache")
+	fs.BoolVar(&config.removeOutput, "remove-output", true, "Remove the output binaries before each build so that they are relinked even if up to date")
+	fs.IntVar(&config.maxLineLength, "max-line-length", interceptor.DefaultMaxLineLength, "Maximum length in bytes of a line of the `go build -x` output")
+	fs.BoolVar(&config.relativeCache, "relative-cache", false, "Store the package files relative to GOCACHE, so that the DB can be used with a Go build cache located elsewhere")
+	fs.BoolVar(&config.force, "force", false, "Delete the link commands already stored for the binaries before storing the new ones instead of updating them, so that they get a new ID and creation time")
+	fs.BoolVar(&config.print, "print", false, "Print the captured link commands")
+	fs.BoolVar(&config.noWrite, "no-write", false, "Don’t store the captured link commands in the DB")
+	fs.StringVar(&config.mainPackage, "main-package", "", "Import path of the main package of the link commands, when it isn’t the last argument of the linker")
+	fs.Var(&config.only, "only", "Only store the link commands whose binary name or main package matches this glob or regular expression (can be repeated)")
+	fs.StringVar(&config.pruneBinary, "prune", "", "Delete the link commands of a binary from the DB instead of intercepting a build")
+	pruneTags := fs.String("prune-tags", "", "Only delete the link command built with these build tags (with -prune)")
+	fs.BoolVar(&config.pruneOrphans, "prune-orphans", false, "Only delete the package files not referenced by any link command")
+	fs.StringVar(&config.exportFile, "export", "", "Write the link commands of the DB to this JSON file, after storing those of the build if one is given")
+	fs.StringVar(&config.importFile, "import", "", "Recreate the link commands of a JSON file written by -export in a new DB instead of intercepting a build")
+	if err := fs.Parse(cmdLine); err != nil {
+		return Config{}, err
+	}
+	config.dbPath = opts.DBPath
+	if config.maxLineLength <= 0 {
+		return Config{}, &cli.UsageError{Msg: "-max-line-length must be positive"}
+	}
+	if config.buildAttempts <= 0 {
+		return Config{}, &cli.UsageError{Msg: "-build-attempts must be positive"}
+	}
+
+	if logger, err = opts.Logger(); err != nil {
+		return Config{}, err
+	}
+	interceptor.Logger = logger
+	if err := opts.SetGoCache(); err != nil {
+		return Config{}, err
+	}
+	if opts.Metrics {
+		config.metrics = cli.NewMetrics()
+	}
+
+	if config.pruneBinary != "" || config.pruneOrphans {
+		config.pruneAnyTags = true
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "prune-tags" {
+				config.pruneAnyTags = false
+			}
+		})
+		config.pruneTags = interceptor.ParseBuildTags(*pruneTags)
+		return
+	}
+	if config.exportFile != "" && config.importFile != "" {
+		return Config{}, &cli.UsageError{Msg: "-export and -import are mutually exclusive"}
+	}
+	if config.importFile != "" || (config.exportFile != "" && fs.NArg() == 0 && config.fromFile == "") {
+		return
+	}
+
+	// The command line of a build output read from a file is only used for
+	// its flags
+	if config.fromFile != "" && fs.NArg() == 0 {
+		return
+	}
+	// `go test` only links the test binaries with `-c`
+	if fs.NArg() < 2 || fs.Arg(0) != "go" || (fs.Arg(1) != "build" && fs.Arg(1) != "install" && (fs.Arg(1) != "test" || !compilesTest(fs.Args()[2:]))) {
+		return Config{}, &cli.UsageError{Msg: fmt.Sprintf("Usage: %s [flags] -- go build -o output [build flags] [packages]\n       %s [flags] -- go install [build flags] [packages]\n       %s [flags] -- go test -c -o output [build flags] [packages]", fs.Name(), fs.Name(), fs.Name())}
+	}
+	config.install = fs.Arg(1) == "install"
+
+	config.args = fs.Args()
+	config.flagsArg = 2
+
+	// Flags can be given either as `-flag value` or as `-flag=value`
+	for i, arg := range fs.Args() {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue && i+1 < fs.NArg() {
+			value = fs.Arg(i + 1)
+		}
+		switch name {
+		case "-o":
+			config.binaryName = value
+			config.outputArg = i
+			if !hasValue {
+				config.outputArg++
+			}
+		case "-C", "--C":
+			config.chdir = value
+			config.flagsArg = i + 1
+			if !hasValue {
+				config.flagsArg++
+			}
+		case "-tags", "--tags":
+			config.buildTags = interceptor.ParseBuildTags(value)
+			config.hasBuildTags = true
+		case "-ldflags", "--ldflags", "-gcflags", "--gcflags":
+			config.buildFlags = append(config.buildFlags, name+"="+value)
+		case "-buildmode", "--buildmode":
+			config.buildFlags = append(config.buildFlags, name+"="+value)
+		case "-trimpath", "--trimpath":
+			config.buildFlags = append(config.buildFlags, arg)
+		case "-race", "--race", "-msan", "--msan", "-asan", "--asan":
+			// Boolean flags only take a value after `=`
+			if enabled, err := strconv.ParseBool(value); hasValue && (err != nil || !enabled) {
+				continue
+			}
+			config.instrumentation = append(config.instrumentation, strings.TrimLeft(name, "-"))
+			config.buildFlags = append(config.buildFlags, arg)
+		}
+	}
+	slices.Sort(config.instrumentation)
+	config.instrumentation = slices.Compact(config.instrumentation)
+	if config.binaryName == "" && !config.install && config.fromFile == "" {
+		return Config{}, &cli.UsageError{Msg: "-o flag is required"}
+	}
+
+	return
+}
+
+// outputPath returns the path of the output binary from the working directory.
+// The go command resolves it from the directory given to `-C`.
+func (c Config) outputPath() string {
+	if c.chdir == "" || c.binaryName == "" || filepath.IsAbs(c.binaryName) {
+		return c.binaryName
+	}
+	return filepath.Join(c.chdir, c.binaryName)
+}
+
+// compilesTest reports whether the `go test` arguments have the `-c` flag,
+// which links the test binaries without running them.
+func compilesTest(args []string) bool {
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "-c" && name != "--c" {
+			continue
+		}
+		if enabled, err := strconv.ParseBool(value); !hasValue || (err == nil && enabled) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBuildLog extracts the link commands from the output of `go build -x`
+// read from the -from-file file.
+func parseBuildLog(ctx context.Context, config Config) (result *interceptor.BuildResult, err error) {
+	r := io.Reader(os.Stdin)
+	if config.fromFile != "-" {
+		f, err := os.Open(config.fromFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open build output: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if result, err = interceptor.ParseBuildOutput(ctx, r, config.maxLineLength); err != nil {
+		return nil, fmt.Errorf("unable to parse Go build output: %w", err)
+	}
+
+	return result, nil
+}
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	buf  []byte
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.size {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.size:]...)
+	}
+	return len(p), nil
+}
+
+// patternFlag is a repeatable command line flag of globs or regular
+// expressions.
+type patternFlag []string
+
+func (p *patternFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternFlag) Set(value string) error {
+	_, globErr := path.Match(value, "")
+	_, reErr := regexp.Compile(value)
+	if globErr != nil && reErr != nil {
+		return fmt.Errorf("%q is neither a valid glob nor a valid regular expression", value)
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// matches reports whether a pattern matches the binary name, its base name or
+// the main package. Regular expressions must match the whole name.
+func (p patternFlag) matches(binaryName, mainPackage string) bool {
+	names := []string{binaryName, filepath.Base(binaryName), mainPackage}
+	for _, pattern := range p {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if err == nil && re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listInstallTargets returns the paths where `go install` installs the
+// binaries, in `GOBIN` or `GOPATH/bin`.
+func listInstallTargets(ctx context.Context, args []string, flagsArg int) ([]string, error) {
+	// `go list` accepts the same build flags as `go install`
+	listArgs := slices.Concat([]string{"list"}, args[2:flagsArg], []string{"-f", "{{if eq .Name \"main\"}}{{.Target}}{{end}}"}, args[flagsArg:])
+	out, err := exec.CommandContext(ctx, args[0], listArgs...).Output() //nolint:gosec
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%w\n%s", err, err.Stderr)
+		}
+		return nil, err
+	}
+
+	// Packages which aren’t commands have an empty line
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), nil
+}
+
+func prune(ctx context.Context, config Config) (err error) {
+	db, err := interceptor.OpenDB(ctx, config.dbPath, config.busyTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to open or create database: %w", err)
+	}
+	defer func() {
+		if err2 := db.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close database: %w", err2))
+		}
+	}()
+
+	var prunedLinkCommands, prunedPackageFiles int64
+	switch {
+	case config.pruneBinary == "":
+		prunedPackageFiles, err = interceptor.PruneOrphans(ctx, db)
+	case config.pruneAnyTags:
+		prunedLinkCommands, prunedPackageFiles, err = interceptor.PruneBinary(ctx, db, config.pruneBinary)
+	default:
+		prunedLinkCommands, prunedPackageFiles, err = interceptor.PruneLinkCommand(ctx, db, config.pruneBinary, config.pruneTags)
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Pruned database", "link_commands", prunedLinkCommands, "package_files", prunedPackageFiles)
+	return nil
+}
+
+// printLinkCommands writes the captured link commands with the binary they
+// are stored for.
+func printLinkCommands(w io.Writer, result *interceptor.BuildResult) error {
+	var buf bytes.Buffer
+	for _, linkCommand := range result.LinkCommands {
+		fmt.Fprintf(&buf, "Binary: %s\n", linkCommand.BinaryName)
+		fmt.Fprintf(&buf, "Command: %s\n", linkCommand.Command)
+		fmt.Fprintln(&buf, "Arguments:")
+		for _, arg := range linkCommand.Args {
+			fmt.Fprintf(&buf, "\t%q\n", arg)
+		}
+	}
+
+	if _, err := buf.WriteTo(w); err != nil {
+		return fmt.Errorf("unable to write link commands: %w", err)
+	}
+
+	return nil
+}
+
+// exportOrImport writes the DB to the export file or fills it from the import
+// file.
+func exportOrImport(ctx context.Context, config Config) (err error) {
+	db, err := interceptor.OpenDB(ctx, config.dbPath, config.busyTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to open or create database: %w", err)
+	}
+	defer func() {
+		if err2 := db.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close database: %w", err2))
+		}
+	}()
+
+	if config.importFile != "" {
+		f, err := os.Open(config.importFile)
+		if err != nil {
+			return fmt.Errorf("unable to open import file: %w", err)
+		}
+		defer f.Close()
+
+		imported, err := interceptor.ImportDB(ctx, db, f)
+		if err != nil {
+			return err
+		}
+		logger.Info("Imported link commands", "link_commands", imported, "path", config.importFile)
+		return nil
+	}
+
+	return exportDB(ctx, db, config.exportFile)
+}
+
+func exportDB(ctx context.Context, db *sql.DB, exportFile string) (err error) {
+	f, err := os.Create(exportFile)
+	if err != nil {
+		return fmt.Errorf("unable to create export file: %w", err)
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close export file: %w", err2))
+		}
+	}()
+
+	if err := interceptor.ExportDB(ctx, db, f); err != nil {
+		return err
+	}
+	logger.Info("Exported link commands", "path", exportFile)
+	return nil
+}
+
+func writeToDB(ctx context.Context, config Config, result *interceptor.BuildResult) (err error) {
+	db, err := interceptor.OpenDB(ctx, config.dbPath, config.busyTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to open or create database: %w", err)
+	}
+	defer func() {
+		if err2 := db.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close database: %w", err2))
+		}
+	}()
+
+	if err := interceptor.Store(ctx, db, result, config.force); err != nil {
+		return err
+	}
+	if config.exportFile != "" {
+		if err := exportDB(ctx, db, config.exportFile); err != nil {
+			return fmt.Errorf("unable to export database: %w", err)
+		}
+	}
+	return nil
+}
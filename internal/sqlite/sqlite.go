@@ -0,0 +1,9 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package sqlite classifies the errors of the sqlite driver.
+// The driver only defines its error type when built with cgo, so the
+// classification is done behind build tags to keep every platform compiling.
+package sqlite
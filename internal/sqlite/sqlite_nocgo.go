@@ -0,0 +1,21 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+//go:build !cgo
+
+package sqlite
+
+// IsBusy reports whether err is due to the database being locked by another
+// connection beyond the busy timeout.
+// Without cgo, the sqlite driver is a stub which never opens a database.
+func IsBusy(error) bool {
+	return false
+}
+
+// IsCorrupt reports whether err is due to the file being corrupt or not being
+// a sqlite database.
+func IsCorrupt(error) bool {
+	return false
+}
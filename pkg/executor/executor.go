@@ -0,0 +1,32 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package executor relinks in-process the binaries whose link command was
+// stored by the interceptor, without running the executor.
+package executor
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/L3n41c/golinkinterceptor/internal/cli"
+	"github.com/L3n41c/golinkinterceptor/internal/execute"
+)
+
+// ReplayOptions control how Replay relinks a binary.
+type ReplayOptions = execute.ReplayOptions
+
+// ExitError is returned by Replay when the binary it spawned exits with a
+// non-zero status, given by Code.
+type ExitError = cli.ExitError
+
+// Replay relinks the binary stored in db under the name binary and the build
+// tags, then writes it to opts.Output or opts.Binary, or executes it. The
+// database is opened with interceptor.OpenDB.
+// With opts.Exec on Unix, the binary replaces the current process and Replay
+// never returns.
+func Replay(ctx context.Context, db *sql.DB, binary string, tags []string, opts ReplayOptions) error {
+	return execute.Replay(ctx, db, binary, tags, opts)
+}
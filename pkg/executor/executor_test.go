@@ -0,0 +1,195 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package executor_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/L3n41c/golinkinterceptor/pkg/executor"
+	"github.com/L3n41c/golinkinterceptor/pkg/interceptor"
+)
+
+// buildHello builds the program of the test directory with `go build -x`
+// until all its package files are in the Go build cache, like the interceptor.
+func buildHello(t *testing.T) *interceptor.BuildResult {
+	t.Helper()
+	ctx := context.Background()
+
+	for range 3 {
+		cmd := exec.CommandContext(ctx, "go", "build", "-x", "-o", filepath.Join(t.TempDir(), "hello"), ".")
+		cmd.Dir = filepath.Join("..", "..", "test")
+		var out bytes.Buffer
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("go build failed: %v\n%s", err, out.String())
+		}
+
+		result, err := interceptor.ParseBuildOutput(ctx, &out, interceptor.DefaultMaxLineLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uncachedFiles, err := interceptor.UncachedPackageFiles(ctx, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uncachedFiles) == 0 {
+			return result
+		}
+	}
+	t.Fatal("package files still not in the Go build cache after 3 builds")
+	return nil
+}
+
+// runHello runs the relinked binary and checks its output.
+func runHello(t *testing.T, binary string) {
+	t.Helper()
+
+	out, err := exec.Command(binary).CombinedOutput()
+	if err != nil {
+		t.Fatalf("relinked binary failed: %v\n%s", err, out)
+	}
+	if got, want := string(out), "Hello unknown!\n"; got != want {
+		t.Errorf("relinked binary printed %q, want %q", got, want)
+	}
+}
+
+// writeV1DB writes the link command of result to a database with the schema of
+// testdata/v1.sql, the way the first version of the interceptor did.
+func writeV1DB(t *testing.T, dbPath string, result *interceptor.BuildResult) {
+	t.Helper()
+	ctx := context.Background()
+
+	schema, err := os.ReadFile(filepath.Join("testdata", "v1.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mustExec := func(query string, args ...any) sql.Result {
+		t.Helper()
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return res
+	}
+	lastInsertID := func(res sql.Result) int64 {
+		t.Helper()
+		id, err := res.LastInsertId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	mustExec(string(schema))
+	buildTagsID := lastInsertID(mustExec(`INSERT INTO build_tags (tags) VALUES (jsonb('null'));`))
+	linkCommandID := lastInsertID(mustExec(`INSERT INTO link_command (binary_name, build_tags_id) VALUES ('hello', ?);`, buildTagsID))
+
+	args := result.LinkCommands[0].Args
+	var importcfg string
+	for i, arg := range args {
+		switch {
+		case i > 0 && args[i-1] == "-o":
+			arg = "PLACEHOLDER"
+		case i > 0 && args[i-1] == "-importcfg":
+			importcfg = arg
+			arg = "PLACEHOLDER"
+		case i == len(args)-1:
+			arg = "MAIN PACKAGE"
+		}
+		mustExec(`INSERT INTO link_command_args (link_command_id, pos, arg) VALUES (?, ?, ?);`, linkCommandID, i, arg)
+	}
+
+	for _, line := range result.Files[importcfg] {
+		directive, argument, _ := strings.Cut(line, " ")
+		packageName, file, ok := strings.Cut(argument, "=")
+		if directive != "packagefile" || !ok {
+			mustExec(`INSERT INTO importcfg_additional_lines (link_command_id, line) VALUES (?, ?);`, linkCommandID, line)
+			continue
+		}
+		packageFileID := lastInsertID(mustExec(`INSERT INTO package_file (package, file) VALUES (?, ?);`, packageName, file))
+		mustExec(`INSERT INTO link_command_package_file (link_command_id, package_file_id) VALUES (?, ?);`, linkCommandID, packageFileID)
+		if file == args[len(args)-1] {
+			mustExec(`UPDATE link_command SET main_package_id = ? WHERE link_command_id = ?;`, packageFileID, linkCommandID)
+		}
+	}
+}
+
+func TestReplayUpgradedV1DB(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "v1.db")
+	writeV1DB(t, dbPath, buildHello(t))
+
+	db, err := interceptor.OpenDB(ctx, dbPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != interceptor.SchemaVersion() {
+		t.Errorf("schema version is %d after the upgrade, want %d", version, interceptor.SchemaVersion())
+	}
+
+	output := filepath.Join(t.TempDir(), "hello")
+	if err := executor.Replay(ctx, db, "hello", nil, executor.ReplayOptions{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	runHello(t, output)
+}
+
+func TestReplayMemoryDB(t *testing.T) {
+	ctx := context.Background()
+	result := buildHello(t)
+	for i, linkCommand := range result.LinkCommands {
+		result.LinkCommands[i].BinaryName = filepath.Base(linkCommand.Output)
+	}
+
+	db, err := interceptor.OpenDB(ctx, interceptor.MemoryDB, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := interceptor.Store(ctx, db, result, false); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "hello")
+	if err := executor.Replay(ctx, db, "hello", nil, executor.ReplayOptions{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	runHello(t, output)
+
+	// The binary streamed to a writer runs like the one written to the output path
+	var binary bytes.Buffer
+	if err := executor.Replay(ctx, db, "hello", nil, executor.ReplayOptions{Binary: &binary}); err != nil {
+		t.Fatal(err)
+	}
+	streamed := filepath.Join(t.TempDir(), "hello")
+	if err := os.WriteFile(streamed, binary.Bytes(), 0o755); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	runHello(t, streamed)
+
+	if err := executor.Replay(ctx, db, "missing", nil, executor.ReplayOptions{Output: output}); err == nil {
+		t.Error("Replay() of a binary that wasn’t stored succeeded")
+	}
+}
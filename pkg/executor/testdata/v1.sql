@@ -0,0 +1,41 @@
+-- Schema of the databases written by the first version of the interceptor,
+-- before link commands were keyed by target platform.
+CREATE TABLE link_command (
+	link_command_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	binary_name     TEXT    NOT NULL,
+	build_tags_id   INTEGER NOT NULL,
+	main_package_id INTEGER,
+	UNIQUE (binary_name, build_tags_id),
+	FOREIGN KEY (build_tags_id) REFERENCES build_tags(build_tags_id),
+	FOREIGN KEY (main_package_id) REFERENCES package_file(package_file_id)
+);
+CREATE TABLE link_command_args (
+	link_command_id INTEGER NOT NULL,
+	pos             INTEGER NOT NULL,
+	arg             TEXT    NOT NULL,
+	PRIMARY KEY (link_command_id, pos),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id)
+);
+CREATE TABLE build_tags (
+	build_tags_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tags          JSONB NOT NULL UNIQUE
+);
+CREATE TABLE package_file (
+	package_file_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	package         TEXT    NOT NULL,
+	file            TEXT    NOT NULL UNIQUE
+);
+CREATE TABLE link_command_package_file (
+	link_command_id INTEGER NOT NULL,
+	package_file_id INTEGER NOT NULL,
+	PRIMARY KEY (link_command_id, package_file_id),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id),
+	FOREIGN KEY (package_file_id) REFERENCES package_file(package_file_id)
+);
+CREATE TABLE importcfg_additional_lines (
+	link_command_id INTEGER NOT NULL,
+	line            TEXT    NOT NULL,
+	PRIMARY KEY (link_command_id, line),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id)
+);
+PRAGMA user_version = 1;
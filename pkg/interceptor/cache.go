@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// goCachePrefix starts the package file paths stored relative to `GOCACHE`.
+// Such paths use slashes as separators whatever the platform.
+const goCachePrefix = "$GOCACHE/"
+
+// RelativizeCachePaths rewrites the package files of result located in
+// `GOCACHE` relative to it, as well as the link command arguments referencing
+// them. The database is then usable with a Go build cache located elsewhere.
+func RelativizeCachePaths(ctx context.Context, result *BuildResult) error {
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+
+	relativize := func(file string) string {
+		rel, err := filepath.Rel(goEnv["GOCACHE"], file)
+		if err != nil || !filepath.IsAbs(file) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return file
+		}
+		return goCachePrefix + filepath.ToSlash(rel)
+	}
+
+	for _, content := range result.Files {
+		for i, line := range content {
+			if !strings.HasPrefix(line, "packagefile") {
+				continue
+			}
+			if packageName, file, ok := strings.Cut(line, "="); ok {
+				content[i] = packageName + "=" + relativize(file)
+			}
+		}
+	}
+	for _, linkCommand := range result.LinkCommands {
+		for i, arg := range linkCommand.Args {
+			linkCommand.Args[i] = relativize(arg)
+		}
+	}
+
+	return nil
+}
+
+// ExpandCachePath returns the absolute path of a package file stored relative
+// to `GOCACHE` by RelativizeCachePaths, found in the local Go build cache.
+// Other paths are returned unchanged.
+func ExpandCachePath(ctx context.Context, file string) (string, error) {
+	rel, ok := strings.CutPrefix(file, goCachePrefix)
+	if !ok {
+		return file, nil
+	}
+
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+
+	return filepath.Join(goEnv["GOCACHE"], filepath.FromSlash(rel)), nil
+}
@@ -0,0 +1,316 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// exportVersion is the version of the JSON export format. It is increased on
+// incompatible changes.
+const exportVersion = 1
+
+// exportedDB is the portable JSON representation of the database.
+type exportedDB struct {
+	Version      int                   `json:"version"`
+	LinkCommands []exportedLinkCommand `json:"link_commands"`
+}
+
+// exportedLinkCommand is a link command with everything it references.
+// Nullable columns are nil when unknown.
+type exportedLinkCommand struct {
+	BinaryName      string                   `json:"binary_name"`
+	BuildTags       []string                 `json:"build_tags"`
+	GOOS            string                   `json:"goos"`
+	GOARCH          string                   `json:"goarch"`
+	Instrumentation string                   `json:"instrumentation"`
+	GoVersion       *string                  `json:"go_version"`
+	BuildFlags      []string                 `json:"build_flags"`
+	ModMode         *string                  `json:"mod_mode"`
+	CgoEnabled      *string                  `json:"cgo_enabled"`
+	WorkDir         *string                  `json:"work_dir"`
+	BuildDir        *string                  `json:"build_dir"`
+	ImportcfgHash   *string                  `json:"importcfg_hash"`
+	Env             map[string]string        `json:"env"`
+	Command         *string                  `json:"command"`
+	CreatedAt       *string                  `json:"created_at"`
+	UpdatedAt       *string                  `json:"updated_at"`
+	BuildMode       string                   `json:"buildmode"`
+	Args            []string                 `json:"args"`
+	MainPackage     *exportedPackageFile     `json:"main_package"`
+	PackageFiles    []exportedPackageFile    `json:"package_files"`
+	AdditionalLines []exportedAdditionalLine `json:"additional_lines"`
+}
+
+type exportedPackageFile struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+}
+
+type exportedAdditionalLine struct {
+	Pos  int    `json:"pos"`
+	Line string `json:"line"`
+}
+
+// ExportDB writes the link commands of the database as JSON, along with their
+// package files and importcfg additional lines. Orphan package files aren’t
+// exported.
+func ExportDB(ctx context.Context, db *sql.DB, w io.Writer) (err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT link_command_id, binary_name, json(tags), goos, goarch, instrumentation, go_version, json(build_flags), mod_mode, cgo_enabled, work_dir, build_dir, importcfg_hash, json(env), command, created_at, updated_at, buildmode, json(args), package_file.package, package_file.file
+FROM link_command
+NATURAL JOIN build_tags
+LEFT JOIN package_file ON link_command.main_package_id = package_file.package_file_id
+ORDER BY link_command_id;`)
+	if err != nil {
+		return fmt.Errorf("unable to query link commands: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close link commands rows: %w", err2))
+		}
+	}()
+
+	export := exportedDB{Version: exportVersion, LinkCommands: []exportedLinkCommand{}}
+	var linkCommandIDs []int64
+	for rows.Next() {
+		var linkCommand exportedLinkCommand
+		var linkCommandID int64
+		var buildTagsJSON, argsJSON string
+		var buildFlagsJSON, envJSON, mainPackage, mainPackageFile sql.NullString
+		if err := rows.Scan(&linkCommandID, &linkCommand.BinaryName, &buildTagsJSON, &linkCommand.GOOS, &linkCommand.GOARCH, &linkCommand.Instrumentation,
+			&linkCommand.GoVersion, &buildFlagsJSON, &linkCommand.ModMode, &linkCommand.CgoEnabled, &linkCommand.WorkDir, &linkCommand.BuildDir, &linkCommand.ImportcfgHash, &envJSON, &linkCommand.Command, &linkCommand.CreatedAt, &linkCommand.UpdatedAt, &linkCommand.BuildMode, &argsJSON, &mainPackage, &mainPackageFile); err != nil {
+			return fmt.Errorf("unable to scan link command: %w", err)
+		}
+		if err := json.Unmarshal([]byte(buildTagsJSON), &linkCommand.BuildTags); err != nil {
+			return fmt.Errorf("unable to unmarshal build tags: %w", err)
+		}
+		if buildFlagsJSON.Valid {
+			if err := json.Unmarshal([]byte(buildFlagsJSON.String), &linkCommand.BuildFlags); err != nil {
+				return fmt.Errorf("unable to unmarshal build flags: %w", err)
+			}
+		}
+		if envJSON.Valid {
+			if err := json.Unmarshal([]byte(envJSON.String), &linkCommand.Env); err != nil {
+				return fmt.Errorf("unable to unmarshal environment: %w", err)
+			}
+		}
+		if err := json.Unmarshal([]byte(argsJSON), &linkCommand.Args); err != nil {
+			return fmt.Errorf("unable to unmarshal link command arguments: %w", err)
+		}
+		if mainPackageFile.Valid {
+			linkCommand.MainPackage = &exportedPackageFile{Package: mainPackage.String, File: mainPackageFile.String}
+		}
+		export.LinkCommands = append(export.LinkCommands, linkCommand)
+		linkCommandIDs = append(linkCommandIDs, linkCommandID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading link commands rows: %w", err)
+	}
+
+	for i, linkCommandID := range linkCommandIDs {
+		if err := exportImportcfg(ctx, tx, linkCommandID, &export.LinkCommands[i]); err != nil {
+			return fmt.Errorf("unable to export importcfg of %s: %w", export.LinkCommands[i].BinaryName, err)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return fmt.Errorf("unable to encode database: %w", err)
+	}
+
+	return nil
+}
+
+// exportImportcfg fills the package files and the additional lines of the
+// importcfg of a link command.
+func exportImportcfg(ctx context.Context, tx *sql.Tx, linkCommandID int64, linkCommand *exportedLinkCommand) (err error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT package, file
+FROM package_file
+NATURAL JOIN link_command_package_file
+WHERE link_command_id = ?
+ORDER BY package, file;`, linkCommandID)
+	if err != nil {
+		return fmt.Errorf("unable to query package files: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close package files rows: %w", err2))
+		}
+	}()
+
+	linkCommand.PackageFiles = []exportedPackageFile{}
+	for rows.Next() {
+		var packageFile exportedPackageFile
+		if err := rows.Scan(&packageFile.Package, &packageFile.File); err != nil {
+			return fmt.Errorf("unable to scan package file: %w", err)
+		}
+		linkCommand.PackageFiles = append(linkCommand.PackageFiles, packageFile)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading package files rows: %w", err)
+	}
+
+	lines, err := tx.QueryContext(ctx, `SELECT pos, line FROM importcfg_additional_lines NATURAL JOIN importcfg_line WHERE link_command_id = ? ORDER BY pos;`, linkCommandID)
+	if err != nil {
+		return fmt.Errorf("unable to query additional lines: %w", err)
+	}
+	defer func() {
+		if err2 := lines.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close additional lines rows: %w", err2))
+		}
+	}()
+
+	linkCommand.AdditionalLines = []exportedAdditionalLine{}
+	for lines.Next() {
+		var line exportedAdditionalLine
+		if err := lines.Scan(&line.Pos, &line.Line); err != nil {
+			return fmt.Errorf("unable to scan additional line: %w", err)
+		}
+		linkCommand.AdditionalLines = append(linkCommand.AdditionalLines, line)
+	}
+	if err := lines.Err(); err != nil {
+		return fmt.Errorf("error reading additional lines rows: %w", err)
+	}
+
+	return nil
+}
+
+// ImportDB recreates the link commands exported by ExportDB. The database
+// must not contain any link command yet.
+func ImportDB(ctx context.Context, db *sql.DB, r io.Reader) (imported int, err error) {
+	var export exportedDB
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return 0, fmt.Errorf("unable to decode export: %w", err)
+	}
+	if export.Version != exportVersion {
+		return 0, fmt.Errorf("unsupported export version %d, expected %d", export.Version, exportVersion)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to rollback transaction: %w", err2))
+			}
+			return
+		}
+		if err2 := tx.Commit(); err2 != nil {
+			err = fmt.Errorf("unable to commit transaction: %w", err2)
+		}
+	}()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM link_command;`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count link commands: %w", err)
+	}
+	if count > 0 {
+		return 0, fmt.Errorf("the database already contains %d link commands, import into a new one", count)
+	}
+
+	stmts, err := prepareStatements(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to prepare statements: %w", err)
+	}
+	defer func() {
+		if err2 := stmts.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close prepared statements: %w", err2))
+		}
+	}()
+
+	for _, linkCommand := range export.LinkCommands {
+		if err := importLinkCommand(ctx, tx, stmts, linkCommand); err != nil {
+			return 0, fmt.Errorf("unable to import link command of %s: %w", linkCommand.BinaryName, err)
+		}
+	}
+
+	return len(export.LinkCommands), nil
+}
+
+func importLinkCommand(ctx context.Context, tx *sql.Tx, stmts *statements, linkCommand exportedLinkCommand) error {
+	buildTagsID, err := insertBuildTags(ctx, tx, linkCommand.BuildTags)
+	if err != nil {
+		return fmt.Errorf("unable to insert build tags: %w", err)
+	}
+
+	buildFlagsJSON, err := json.Marshal(linkCommand.BuildFlags)
+	if err != nil {
+		return fmt.Errorf("unable to marshal build flags: %w", err)
+	}
+	// The environment of the link commands exported before it was recorded
+	// stays unknown
+	var envJSON sql.NullString
+	if linkCommand.Env != nil {
+		env, err := json.Marshal(linkCommand.Env)
+		if err != nil {
+			return fmt.Errorf("unable to marshal environment: %w", err)
+		}
+		envJSON = sql.NullString{String: string(env), Valid: true}
+	}
+	argsJSON, err := json.Marshal(linkCommand.Args)
+	if err != nil {
+		return fmt.Errorf("unable to marshal link command arguments: %w", err)
+	}
+
+	var buildDir string
+	if linkCommand.BuildDir != nil {
+		buildDir = *linkCommand.BuildDir
+	}
+	binaryPath := BinaryPath(linkCommand.BinaryName, buildDir)
+
+	var linkCommandID int64
+	row := tx.QueryRowContext(ctx, `
+INSERT INTO link_command (binary_name, build_tags_id, goos, goarch, instrumentation, go_version, build_flags, mod_mode, cgo_enabled, work_dir, build_dir, binary_path, importcfg_hash, env, command, created_at, updated_at, buildmode, args)
+VALUES (?, ?, ?, ?, ?, ?, jsonb(?), ?, ?, ?, ?, ?, ?, jsonb(?), ?, ?, ?, ?, jsonb(?))
+RETURNING link_command_id;`,
+		linkCommand.BinaryName, buildTagsID, linkCommand.GOOS, linkCommand.GOARCH, linkCommand.Instrumentation, linkCommand.GoVersion, buildFlagsJSON, linkCommand.ModMode, linkCommand.CgoEnabled, linkCommand.WorkDir, linkCommand.BuildDir, binaryPath, linkCommand.ImportcfgHash, envJSON, linkCommand.Command, linkCommand.CreatedAt, linkCommand.UpdatedAt, linkCommand.BuildMode, argsJSON)
+	if err := row.Scan(&linkCommandID); err != nil {
+		return fmt.Errorf("unable to insert link command: %w", err)
+	}
+
+	lines := make([]string, len(linkCommand.PackageFiles))
+	for i, packageFile := range linkCommand.PackageFiles {
+		lines[i] = "packagefile " + packageFile.Package + "=" + packageFile.File
+	}
+	if err := insertPackageFiles(ctx, tx, stmts, linkCommandID, lines); err != nil {
+		return fmt.Errorf("unable to insert package files: %w", err)
+	}
+	for _, line := range linkCommand.AdditionalLines {
+		if err := insertAdditionalLines(ctx, stmts, linkCommandID, line.Pos, line.Line); err != nil {
+			return fmt.Errorf("unable to insert additional lines: %w", err)
+		}
+	}
+
+	if linkCommand.MainPackage != nil {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO package_file (package, file) VALUES (?, ?) ON CONFLICT (file) DO NOTHING;`, linkCommand.MainPackage.Package, linkCommand.MainPackage.File); err != nil {
+			return fmt.Errorf("unable to insert main package: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+UPDATE link_command
+SET main_package_id = (SELECT package_file_id FROM package_file WHERE file = ?)
+WHERE link_command_id = ?;`, linkCommand.MainPackage.File, linkCommandID); err != nil {
+			return fmt.Errorf("unable to set main package: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,528 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package interceptor extracts the link commands from the output of
+// `go build -x` and stores them in a sqlite database so that binaries can be
+// relinked later without rebuilding them.
+package interceptor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Logger reports the progress of the interception.
+// It discards everything by default.
+var Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// BuildEnvVars are the environment variables of the Go toolchain recorded
+// along with the link commands. Only these are recorded, as others may hold
+// secrets.
+var BuildEnvVars = []string{"GOFLAGS", "GOEXPERIMENT", "GOFIPS140", "GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM"}
+
+// BuildResult is what was extracted from the output of `go build -x`.
+type BuildResult struct {
+	LinkCommands []LinkCommand
+	// Files is the content of the files written by the build, keyed by path
+	Files map[string][]string
+	// BuildTags are the sorted build tags the binaries were built with
+	BuildTags []string
+	// BuildFlags are the `go build` flags affecting the produced binaries,
+	// like `-ldflags`, `-gcflags` or `-trimpath`
+	BuildFlags []string
+	GOOS       string
+	GOARCH     string
+	GoVersion  string // Version of the Go toolchain that built the binaries
+	// ModMode is the value of the `-mod` build flag, empty if not set
+	ModMode string
+	// Instrumentation is the sorted instrumentations enabled by the `-race`,
+	// `-msan` or `-asan` build flags, like `race`
+	Instrumentation []string
+	// CgoEnabled is the value of `CGO_ENABLED` during the build, `0` or `1`.
+	// The objects of cgo packages are packed into their package files, but
+	// relinking them may still need the external linker.
+	CgoEnabled string
+	// Env is the value of the non-empty BuildEnvVars during the build
+	Env map[string]string
+	// WorkDir is the temporary work directory of the build, removed at its end
+	WorkDir string
+	// BuildDir is the working directory of the build, which relative binary
+	// names are relative to
+	BuildDir string
+}
+
+// LinkCommand is a link step found in the `go build -x` output.
+type LinkCommand struct {
+	Args       []string
+	Command    string // Line of the build output running the linker, as printed
+	Output     string // Final location of the binary produced by the linker
+	BinaryName string // Name under which the link command is stored
+	BuildMode  string // Value of the `-buildmode` linker flag
+	// MainPackage is the import path of the main package when it isn’t
+	// detected from the last argument of the linker
+	MainPackage string
+}
+
+// maxEnvVarExpansions is the maximum number of passes expanding the
+// environment variables of a line of the build output.
+const maxEnvVarExpansions = 10
+
+// ErrNoLinkCommand is returned by ParseBuildOutput when the build didn’t link
+// any binary.
+var ErrNoLinkCommand = errors.New("no link command found in the build output, only main packages are linked into binaries")
+
+var cachedGoEnvVar map[string]string
+
+func getGoEnvVar(ctx context.Context) (map[string]string, error) {
+	if cachedGoEnvVar == nil {
+		out, err := exec.CommandContext(ctx, "go", "env", "-json").Output()
+		if err != nil {
+			if err, ok := err.(*exec.ExitError); ok {
+				return nil, fmt.Errorf("unable to get Go environment: %w\n%s", err, err.Stderr)
+			}
+			return nil, fmt.Errorf("unable to get Go environment: %w", err)
+		}
+
+		err = json.Unmarshal(out, &cachedGoEnvVar)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal Go environment: %w", err)
+		}
+	}
+
+	return cachedGoEnvVar, nil
+}
+
+// Linker returns the path of the linker of the Go toolchain, found in
+// `GOTOOLDIR`.
+func Linker(ctx context.Context) (string, error) {
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+	if goEnv["GOTOOLDIR"] == "" {
+		return "", errors.New("GOTOOLDIR is not set in the Go environment")
+	}
+
+	linker := filepath.Join(goEnv["GOTOOLDIR"], "link")
+	// The tools are built for the host
+	if runtime.GOOS == "windows" {
+		linker += ".exe"
+	}
+
+	return linker, nil
+}
+
+// DefaultMaxLineLength is the default maximum length of a line of the
+// `go build -x` output. Link commands with large `-ldflags`, as well as the
+// `modinfo` line of their importcfg, can exceed the 64 KiB default of
+// bufio.Scanner.
+const DefaultMaxLineLength = 16 << 20
+
+// ParseBuildOutput extracts the link commands and the content of the files
+// written by the build from the output of `go build -x`, read line by line
+// from r. Lines longer than maxLineLength bytes are reported as an error.
+func ParseBuildOutput(ctx context.Context, r io.Reader, maxLineLength int) (*BuildResult, error) {
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+
+	// The work directory is printed unquoted, even if it contains spaces.
+	workDirDefRe := regexp.MustCompile(`^(WORK)=(.*)$`)
+	envVarDefRe := regexp.MustCompile(`^(\w+)=(\S*)$`)
+	envVarRe := regexp.MustCompile(`\$\w+`)
+	startFileRe := regexp.MustCompile(`^cat > *(.+?) *<< 'EOF' *(?:#.*)?$`)
+	endFileRe := regexp.MustCompile(`^EOF$`)
+	linkCommandRe := linkCommandRegexp(goEnv["GOTOOLDIR"])
+	moveRe := regexp.MustCompile(`^(?:mv|cp) (.*)$`)
+
+	result := &BuildResult{
+		LinkCommands: make([]LinkCommand, 0, 1),
+		Files:        make(map[string][]string),
+		GOOS:         goEnv["GOOS"],
+		GOARCH:       goEnv["GOARCH"],
+		GoVersion:    goEnv["GOVERSION"],
+		ModMode:      ModMode(strings.Fields(goEnv["GOFLAGS"])),
+		BuildTags:    goFlagsBuildTags(goEnv["GOFLAGS"]),
+		CgoEnabled:   goEnv["CGO_ENABLED"],
+		Env:          buildEnv(goEnv),
+	}
+	moves := make(map[string]string)
+
+	currentFile := ""
+	envVarMap := make(map[string]string)
+	// Values may reference variables defined afterwards, so the expansion is
+	// repeated until nothing changes. The number of passes is capped in case
+	// variables reference each other.
+	expandEnvVars := func(s string) string {
+		for range maxEnvVarExpansions {
+			expanded := envVarRe.ReplaceAllStringFunc(s, func(s string) string {
+				if val, ok := envVarMap[s[1:]]; ok {
+					return val
+				}
+				return s
+			})
+			if expanded == s {
+				break
+			}
+			s = expanded
+		}
+		return s
+	}
+	// Commands are split into words before expanding the environment
+	// variables so that values containing spaces stay in a single word.
+	splitCommand := func(command string) ([]string, error) {
+		words, err := splitShellWords(command)
+		for i, word := range words {
+			words[i] = expandEnvVars(word)
+		}
+		return words, err
+	}
+
+	// Lines are split by bufio.ScanLines, which also strips the carriage
+	// return of output captured with CRLF line endings
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(bufio.MaxScanTokenSize, maxLineLength)), maxLineLength)
+	for scanner.Scan() {
+		line := expandEnvVars(scanner.Text())
+		switch {
+		case workDirDefRe.MatchString(line) || envVarDefRe.MatchString(line):
+			matches := workDirDefRe.FindStringSubmatch(line)
+			if matches == nil {
+				matches = envVarDefRe.FindStringSubmatch(line)
+			}
+			envVarMap[matches[1]] = matches[2]
+			Logger.Debug("Environment variable", "line", line)
+		case endFileRe.MatchString(line):
+			Logger.Debug("End of file", "file", currentFile, "line", line)
+			currentFile = ""
+		case currentFile != "":
+			Logger.Debug("Content of file", "file", currentFile, "line", line)
+			result.Files[currentFile] = append(result.Files[currentFile], line)
+		case startFileRe.MatchString(line):
+			if matches := startFileRe.FindStringSubmatch(line); matches != nil {
+				currentFile = matches[1]
+				// `go build -x` doesn’t quote the file name but a quoted one
+				// is unquoted the way the shell would.
+				if strings.ContainsAny(currentFile[:1], `'"`) {
+					words, err := splitShellWords(currentFile)
+					if err != nil || len(words) != 1 {
+						return nil, fmt.Errorf("unable to unquote file name %s: %w", currentFile, err)
+					}
+					currentFile = words[0]
+				}
+			}
+			Logger.Debug("Start of file", "file", currentFile, "line", line)
+		case linkCommandRe.MatchString(line) || anyLinkCommandRe.MatchString(line):
+			re := linkCommandRe
+			if !linkCommandRe.MatchString(line) {
+				re = anyLinkCommandRe
+				Logger.Warn("Linker found outside of GOTOOLDIR", "gotooldir", goEnv["GOTOOLDIR"], "line", line)
+			}
+			// The link command may be run by a shell, like in
+			// `sh -c 'cd $WORK && .../link ...'`
+			command, err := unwrapShellCommand(scanner.Text())
+			if err != nil {
+				return nil, fmt.Errorf("unable to unwrap link command: %w", err)
+			}
+			if matches := re.FindStringSubmatch(command); matches != nil {
+				args, err := splitCommand(matches[1])
+				if err != nil {
+					return nil, fmt.Errorf("unable to split link command into arguments: %w", err)
+				}
+				result.LinkCommands = append(result.LinkCommands, LinkCommand{Args: args, Command: scanner.Text(), BuildMode: linkBuildMode(args)})
+			}
+			Logger.Debug("Link command found", "line", line)
+		case moveRe.MatchString(line):
+			if matches := moveRe.FindStringSubmatch(scanner.Text()); matches != nil {
+				if args, err := splitCommand(matches[1]); err == nil && len(args) == 2 {
+					moves[args[0]] = args[1]
+				}
+			}
+			Logger.Debug("File moved", "line", line)
+		default:
+			Logger.Debug("Ignored line", "line", line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line longer than %d bytes in the build output: %w", maxLineLength, err)
+		}
+		return nil, fmt.Errorf("unable to read build output: %w", err)
+	}
+
+	result.WorkDir = envVarMap["WORK"]
+
+	// Building libraries only compiles them
+	if len(result.LinkCommands) == 0 {
+		return nil, ErrNoLinkCommand
+	}
+
+	// The linker writes the binary in the work directory, it’s then moved to
+	// its final location.
+	for i, linkCommand := range result.LinkCommands {
+		for j, arg := range linkCommand.Args {
+			if output, ok := strings.CutPrefix(arg, "-o="); ok {
+				result.LinkCommands[i].Output = moves[output]
+			} else if arg == "-o" && j+1 < len(linkCommand.Args) {
+				result.LinkCommands[i].Output = moves[linkCommand.Args[j+1]]
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// MainPackage returns the import path of the main package linked by
+// linkCommand. Unless set on linkCommand, it’s the package of the importcfg
+// whose file is the last argument of the linker, empty if not found.
+func (r *BuildResult) MainPackage(linkCommand LinkCommand) string {
+	if linkCommand.MainPackage != "" {
+		return linkCommand.MainPackage
+	}
+	if len(linkCommand.Args) == 0 {
+		return ""
+	}
+	mainFile := linkCommand.Args[len(linkCommand.Args)-1]
+
+	var importcfg string
+	for i, arg := range linkCommand.Args {
+		if file, ok := strings.CutPrefix(arg, "-importcfg="); ok {
+			importcfg = file
+		} else if arg == "-importcfg" && i+1 < len(linkCommand.Args) {
+			importcfg = linkCommand.Args[i+1]
+		}
+	}
+
+	for _, line := range r.Files[importcfg] {
+		line, ok := strings.CutPrefix(line, "packagefile ")
+		if !ok {
+			continue
+		}
+		if packageName, file, ok := strings.Cut(line, "="); ok && file == mainFile {
+			return packageName
+		}
+	}
+
+	return ""
+}
+
+// BinaryPath returns the absolute path of a binary built in buildDir, or nil
+// if it can’t be known.
+func BinaryPath(binaryName, buildDir string) *string {
+	if filepath.IsAbs(binaryName) {
+		return &binaryName
+	}
+	if buildDir == "" {
+		return nil
+	}
+	binaryPath := filepath.Join(buildDir, binaryName)
+	return &binaryPath
+}
+
+// WorkDirArgs returns the arguments of the link commands referencing the work
+// directory of the build, other than their output, importcfg and main package.
+// Such inputs, like objects added with `-extldflags` to the link of a cgo
+// program, are removed at the end of the build and can’t be relinked.
+func WorkDirArgs(result *BuildResult) []string {
+	if result.WorkDir == "" {
+		return nil
+	}
+
+	var args []string
+	for _, linkCommand := range result.LinkCommands {
+		for i, arg := range linkCommand.Args {
+			switch {
+			case i == len(linkCommand.Args)-1,
+				strings.HasPrefix(arg, "-o="), strings.HasPrefix(arg, "-importcfg="),
+				i > 0 && (linkCommand.Args[i-1] == "-o" || linkCommand.Args[i-1] == "-importcfg"):
+				continue
+			}
+			if strings.Contains(arg, result.WorkDir) {
+				args = append(args, arg)
+			}
+		}
+	}
+
+	return args
+}
+
+// CanonicalBuildTags returns the build tags sorted, deduplicated and
+// comma-separated, the key they are stored and looked up by. It is empty for
+// no tags, whether nil or empty.
+func CanonicalBuildTags(buildTags []string) string {
+	return strings.Join(ParseBuildTags(strings.Join(buildTags, ",")), ",")
+}
+
+// ParseBuildTags returns the sorted and deduplicated build tags of the value of
+// a `-tags` flag. Like for `go build`, tags are separated by commas or, in the
+// legacy form, by spaces.
+// No tags is always nil, so that it is stored and looked up the same way.
+func ParseBuildTags(value string) []string {
+	buildTags := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(buildTags) == 0 {
+		return nil
+	}
+	slices.Sort(buildTags)
+	return slices.Compact(buildTags)
+}
+
+// GoFlags returns the flags of `go env GOFLAGS`, which the builds are run with.
+// Contrary to the GOFLAGS environment variable, they include those set by
+// `go env -w`.
+func GoFlags(ctx context.Context) ([]string, error) {
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+
+	return strings.Fields(goEnv["GOFLAGS"]), nil
+}
+
+// ModMode returns the value of the last `-mod` flag among flags, which can be
+// given either as `-flag value` or as `-flag=value`.
+func ModMode(flags []string) (modMode string) {
+	for i, flag := range flags {
+		name, value, hasValue := strings.Cut(flag, "=")
+		if name != "-mod" && name != "--mod" {
+			continue
+		}
+		if !hasValue && i+1 < len(flags) {
+			value = flags[i+1]
+		}
+		modMode = value
+	}
+
+	return modMode
+}
+
+// buildEnv returns the non-empty BuildEnvVars of the Go environment.
+func buildEnv(goEnv map[string]string) map[string]string {
+	env := make(map[string]string)
+	for _, name := range BuildEnvVars {
+		if value := goEnv[name]; value != "" {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// goFlagsBuildTags returns the build tags set by GOFLAGS.
+func goFlagsBuildTags(goFlags string) []string {
+	buildTags, _ := BuildTagsFlag(strings.Fields(goFlags))
+	return buildTags
+}
+
+// BuildTagsFlag returns the build tags of the last `-tags` flag among flags,
+// which can be given either as `-flag value` or as `-flag=value`, and whether
+// there is one.
+func BuildTagsFlag(flags []string) (buildTags []string, ok bool) {
+	for i, flag := range flags {
+		name, value, hasValue := strings.Cut(flag, "=")
+		if name != "-tags" && name != "--tags" {
+			continue
+		}
+		if !hasValue && i+1 < len(flags) {
+			value = flags[i+1]
+		}
+		buildTags, ok = ParseBuildTags(value), true
+	}
+
+	return buildTags, ok
+}
+
+// linkBuildMode returns the value of the `-buildmode` flag of a link command.
+// The linker defaults to the exe build mode.
+func linkBuildMode(args []string) string {
+	for i, arg := range args {
+		if buildMode, ok := strings.CutPrefix(arg, "-buildmode="); ok {
+			return buildMode
+		}
+		if arg == "-buildmode" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return "exe"
+}
+
+// pathSeparatorRe matches a path separator in the build output. On Windows,
+// `go build -x` quotes the words containing backslashes like strconv.Quote,
+// which doubles them.
+const pathSeparatorRe = `(?:/|\\\\?)`
+
+// linkerRe matches the end of the path of the linker, which is quoted if it
+// contains backslashes.
+const linkerRe = pathSeparatorRe + `link(?:\.exe)?"?`
+
+// anyLinkCommandRe matches the invocation of a linker located anywhere, as
+// wrappers of the go command may run one located elsewhere, like in a
+// symlinked toolchain.
+// The linker must be the command word, at the start of the line or after
+// `&& `, so that a compile command of a package whose import path ends in
+// `/link` isn’t mistaken for a link command. Its arguments must hold both
+// `-importcfg` and `-buildmode=`, which the go command passes to the linker
+// but not to the compiler.
+var anyLinkCommandRe = regexp.MustCompile(`^(?:.*&& )?(?:[A-Za-z_][A-Za-z0-9_]*=(?:'[^']*'|[^\s']*) )*(?:"[^"]*|[^\s"']*)` + linkerRe +
+	` ((?:.* )?(?:-importcfg[ =].* -buildmode=|-buildmode=.* -importcfg[ =]).*)$`)
+
+// linkCommandRegexp returns a regexp matching the invocation of the linker
+// located in goToolDir and capturing its arguments.
+// The linker of the same tool directory in another GOROOT is matched too, for
+// build outputs read from a file written on another machine.
+// Both path separators, the `.exe` suffix and the quoting of Windows are
+// tolerated.
+func linkCommandRegexp(goToolDir string) *regexp.Regexp {
+	separatorRe := regexp.MustCompile(`[/\\]`)
+	parts := separatorRe.Split(goToolDir, -1)
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	anyGOROOT := []string{"", "pkg", "tool", parts[len(parts)-1]}
+
+	return regexp.MustCompile(`^.*(?:` + strings.Join(parts, pathSeparatorRe) + `|` + strings.Join(anyGOROOT, pathSeparatorRe) + `)` + linkerRe + ` (.*)$`)
+}
+
+// UncachedPackageFiles returns the sorted package files referenced by the
+// build which aren’t located in the Go build cache.
+func UncachedPackageFiles(ctx context.Context, result *BuildResult) ([]string, error) {
+	goEnv, err := getGoEnvVar(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Go environment variables: %w", err)
+	}
+
+	var files []string
+	for _, content := range result.Files {
+		for _, line := range content {
+			if !strings.HasPrefix(line, "packagefile") {
+				continue
+			}
+			_, file, err := parsePackageFileLine(line)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.Contains(file, goEnv["GOCACHE"]) {
+				files = append(files, file)
+			}
+		}
+	}
+	slices.Sort(files)
+
+	return slices.Compact(files), nil
+}
@@ -0,0 +1,372 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setGoEnv makes the Go environment variables those of a build on another
+// machine for the duration of the test, so that build outputs don’t depend on
+// the local toolchain.
+func setGoEnv(t *testing.T) {
+	t.Helper()
+
+	saved := cachedGoEnvVar
+	cachedGoEnvVar = map[string]string{
+		"CGO_ENABLED": "1",
+		"GOARCH":      "amd64",
+		"GOCACHE":     "/home/gopher/.cache/go-build",
+		"GOFLAGS":     "-mod=vendor -tags=b,a",
+		"GOOS":        "linux",
+		"GOTOOLDIR":   "/opt/go/pkg/tool/linux_amd64",
+		"GOVERSION":   "go1.27.1",
+	}
+	t.Cleanup(func() { cachedGoEnvVar = saved })
+}
+
+// linkBuildOutput is the output of `go build -x -o hello .`, linking a main
+// package with fmt.
+const linkBuildOutput = `WORK=/tmp/go-build1
+mkdir -p $WORK/b001/
+cat >/tmp/go-build1/b001/importcfg.link << 'EOF' # internal
+packagefile main=/home/gopher/.cache/go-build/aa/aa-d
+packagefile fmt=/home/gopher/.cache/go-build/bb/bb-d
+modinfo "hello"
+EOF
+mkdir -p $WORK/b001/exe/
+cd .
+GOROOT='/opt/go' /opt/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link -buildmode=exe /home/gopher/.cache/go-build/aa/aa-d
+/opt/go/pkg/tool/linux_amd64/buildid -w $WORK/b001/exe/a.out # internal
+mv $WORK/b001/exe/a.out hello
+rm -rf $WORK/b001/
+`
+
+func TestParseBuildOutput(t *testing.T) {
+	setGoEnv(t)
+
+	type linkCommand struct {
+		args      []string
+		output    string
+		buildMode string
+	}
+	helloLinkCommand := linkCommand{
+		args:      []string{"-o", "/tmp/go-build1/b001/exe/a.out", "-importcfg", "/tmp/go-build1/b001/importcfg.link", "-buildmode=exe", "/home/gopher/.cache/go-build/aa/aa-d"},
+		output:    "hello",
+		buildMode: "exe",
+	}
+	helloFiles := map[string][]string{
+		"/tmp/go-build1/b001/importcfg.link": {
+			"packagefile main=/home/gopher/.cache/go-build/aa/aa-d",
+			"packagefile fmt=/home/gopher/.cache/go-build/bb/bb-d",
+			`modinfo "hello"`,
+		},
+	}
+	tests := []struct {
+		name          string
+		output        string
+		maxLineLength int
+		wantErr       error
+		want          []linkCommand
+		wantFiles     map[string][]string
+		wantWorkDir   string
+	}{
+		{
+			name:        "link command",
+			output:      linkBuildOutput,
+			want:        []linkCommand{helloLinkCommand},
+			wantFiles:   helloFiles,
+			wantWorkDir: "/tmp/go-build1",
+		},
+		{
+			name:        "CRLF line endings",
+			output:      strings.ReplaceAll(linkBuildOutput, "\n", "\r\n"),
+			want:        []linkCommand{helloLinkCommand},
+			wantFiles:   helloFiles,
+			wantWorkDir: "/tmp/go-build1",
+		},
+		{
+			name: "work directory with spaces",
+			output: `WORK=/tmp/my builds/go-build2
+cat >/tmp/my builds/go-build2/b001/importcfg.link << 'EOF' # internal
+packagefile main=/home/gopher/.cache/go-build/aa/aa-d
+EOF
+/opt/go/pkg/tool/linux_amd64/link -o "$WORK/b001/exe/a.out" -importcfg "$WORK/b001/importcfg.link" -buildmode=pie /home/gopher/.cache/go-build/aa/aa-d
+mv "$WORK/b001/exe/a.out" "hello world"
+`,
+			want: []linkCommand{{
+				args:      []string{"-o", "/tmp/my builds/go-build2/b001/exe/a.out", "-importcfg", "/tmp/my builds/go-build2/b001/importcfg.link", "-buildmode=pie", "/home/gopher/.cache/go-build/aa/aa-d"},
+				output:    "hello world",
+				buildMode: "pie",
+			}},
+			wantFiles: map[string][]string{
+				"/tmp/my builds/go-build2/b001/importcfg.link": {"packagefile main=/home/gopher/.cache/go-build/aa/aa-d"},
+			},
+			wantWorkDir: "/tmp/my builds/go-build2",
+		},
+		{
+			name: "several binaries",
+			output: `WORK=/tmp/go-build3
+cat >/tmp/go-build3/b001/importcfg.link << 'EOF' # internal
+packagefile main=/home/gopher/.cache/go-build/aa/aa-d
+EOF
+cat >/tmp/go-build3/b002/importcfg.link << 'EOF' # internal
+packagefile main=/home/gopher/.cache/go-build/cc/cc-d
+EOF
+/opt/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link /home/gopher/.cache/go-build/aa/aa-d
+/opt/go/pkg/tool/linux_amd64/link -o $WORK/b002/exe/a.out -importcfg $WORK/b002/importcfg.link /home/gopher/.cache/go-build/cc/cc-d
+cp $WORK/b001/exe/a.out bin/hello
+cp $WORK/b002/exe/a.out bin/bye
+`,
+			want: []linkCommand{
+				{
+					args:      []string{"-o", "/tmp/go-build3/b001/exe/a.out", "-importcfg", "/tmp/go-build3/b001/importcfg.link", "/home/gopher/.cache/go-build/aa/aa-d"},
+					output:    "bin/hello",
+					buildMode: "exe",
+				},
+				{
+					args:      []string{"-o", "/tmp/go-build3/b002/exe/a.out", "-importcfg", "/tmp/go-build3/b002/importcfg.link", "/home/gopher/.cache/go-build/cc/cc-d"},
+					output:    "bin/bye",
+					buildMode: "exe",
+				},
+			},
+			wantFiles: map[string][]string{
+				"/tmp/go-build3/b001/importcfg.link": {"packagefile main=/home/gopher/.cache/go-build/aa/aa-d"},
+				"/tmp/go-build3/b002/importcfg.link": {"packagefile main=/home/gopher/.cache/go-build/cc/cc-d"},
+			},
+			wantWorkDir: "/tmp/go-build3",
+		},
+		{
+			name: "library",
+			output: `WORK=/tmp/go-build4
+/opt/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -trimpath "$WORK/b001=>" -p greet -importcfg $WORK/b001/importcfg -pack ./greet.go
+`,
+			wantErr: ErrNoLinkCommand,
+		},
+		{
+			name:          "line too long",
+			output:        linkBuildOutput,
+			maxLineLength: 64,
+			wantErr:       bufio.ErrTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseBuildOutput(context.Background(), strings.NewReader(tt.output), cmp.Or(tt.maxLineLength, DefaultMaxLineLength))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseBuildOutput() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.LinkCommands) != len(tt.want) {
+				t.Fatalf("ParseBuildOutput() found %d link commands, want %d", len(result.LinkCommands), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := result.LinkCommands[i]
+				if !slices.Equal(got.Args, want.args) {
+					t.Errorf("link command %d args = %q, want %q", i, got.Args, want.args)
+				}
+				if got.Output != want.output {
+					t.Errorf("link command %d output = %q, want %q", i, got.Output, want.output)
+				}
+				if got.BuildMode != want.buildMode {
+					t.Errorf("link command %d build mode = %q, want %q", i, got.BuildMode, want.buildMode)
+				}
+			}
+			if !maps.EqualFunc(result.Files, tt.wantFiles, slices.Equal) {
+				t.Errorf("files = %q, want %q", result.Files, tt.wantFiles)
+			}
+			if result.WorkDir != tt.wantWorkDir {
+				t.Errorf("work directory = %q, want %q", result.WorkDir, tt.wantWorkDir)
+			}
+			// The module mode and the build tags set by GOFLAGS are recorded
+			if result.ModMode != "vendor" {
+				t.Errorf("module mode = %q, want %q", result.ModMode, "vendor")
+			}
+			if want := []string{"a", "b"}; !slices.Equal(result.BuildTags, want) {
+				t.Errorf("build tags = %q, want %q", result.BuildTags, want)
+			}
+		})
+	}
+}
+
+func TestUncachedPackageFiles(t *testing.T) {
+	setGoEnv(t)
+
+	tests := []struct {
+		name    string
+		files   map[string][]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "cached",
+			files: map[string][]string{
+				"/tmp/go-build1/b001/importcfg.link": {
+					"packagefile main=/home/gopher/.cache/go-build/aa/aa-d",
+					"packagefile fmt=/home/gopher/.cache/go-build/bb/bb-d",
+				},
+			},
+		},
+		{
+			name: "uncached",
+			files: map[string][]string{
+				"/tmp/go-build1/b001/importcfg.link": {
+					"# import config",
+					"packagefile main=/tmp/go-build1/b001/_pkg_.a",
+					"packagefile fmt=/home/gopher/.cache/go-build/bb/bb-d",
+					"packagefile greet=/tmp/go-build1/b002/_pkg_.a",
+					`modinfo "hello"`,
+				},
+			},
+			want: []string{"/tmp/go-build1/b001/_pkg_.a", "/tmp/go-build1/b002/_pkg_.a"},
+		},
+		{
+			name: "shared by several binaries",
+			files: map[string][]string{
+				"/tmp/go-build1/b001/importcfg.link": {
+					"packagefile main=/tmp/go-build1/b001/_pkg_.a",
+					"packagefile greet=/tmp/go-build1/b003/_pkg_.a",
+				},
+				"/tmp/go-build1/b002/importcfg.link": {
+					"packagefile main=/tmp/go-build1/b002/_pkg_.a",
+					"packagefile greet=/tmp/go-build1/b003/_pkg_.a",
+				},
+			},
+			want: []string{"/tmp/go-build1/b001/_pkg_.a", "/tmp/go-build1/b002/_pkg_.a", "/tmp/go-build1/b003/_pkg_.a"},
+		},
+		{
+			name: "invalid line",
+			files: map[string][]string{
+				"/tmp/go-build1/b001/importcfg.link": {"packagefile main"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UncachedPackageFiles(context.Background(), &BuildResult{Files: tt.files})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UncachedPackageFiles() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("UncachedPackageFiles() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkCommandRegexp(t *testing.T) {
+	tests := []struct {
+		name      string
+		goToolDir string
+		line      string
+		want      []string // nil if the line doesn’t run the linker
+	}{
+		{
+			name:      "unix",
+			goToolDir: "/usr/local/go/pkg/tool/linux_amd64",
+			line:      "GOROOT='/usr/local/go' /usr/local/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link -buildmode=exe $WORK/b001/_pkg_.a",
+			want:      []string{"-o", "$WORK/b001/exe/a.out", "-importcfg", "$WORK/b001/importcfg.link", "-buildmode=exe", "$WORK/b001/_pkg_.a"},
+		},
+		{
+			name:      "backslash paths",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"C:\\Go\\pkg\\tool\\windows_amd64\\link.exe" -o "$WORK\\b001\\exe\\a.out.exe" -importcfg "$WORK\\b001\\importcfg.link" -buildmode=exe "C:\\Users\\gopher\\AppData\\Local\\go-build\\fc\\fc28-d"`,
+			want:      []string{"-o", `$WORK\b001\exe\a.out.exe`, "-importcfg", `$WORK\b001\importcfg.link`, "-buildmode=exe", `C:\Users\gopher\AppData\Local\go-build\fc\fc28-d`},
+		},
+		{
+			name:      "GOROOT with spaces",
+			goToolDir: `C:\Program Files\Go\pkg\tool\windows_amd64`,
+			line:      `GOROOT='C:\Program Files\Go' "C:\\Program Files\\Go\\pkg\\tool\\windows_amd64\\link.exe" -o "$WORK\\b001\\exe\\a.out.exe" -importcfg "$WORK\\b001\\importcfg.link" "$WORK\\b001\\_pkg_.a"`,
+			want:      []string{"-o", `$WORK\b001\exe\a.out.exe`, "-importcfg", `$WORK\b001\importcfg.link`, `$WORK\b001\_pkg_.a`},
+		},
+		{
+			name:      "quoted args",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"C:\\Go\\pkg\\tool\\windows_amd64\\link.exe" -o "$WORK\\b001\\exe\\a.out.exe" -importcfg "$WORK\\b001\\importcfg.link" "-X=main.version=v1 beta" "-extld=C:\\Program Files\\gcc\\bin\\gcc.exe" "$WORK\\b001\\_pkg_.a"`,
+			want:      []string{"-o", `$WORK\b001\exe\a.out.exe`, "-importcfg", `$WORK\b001\importcfg.link`, "-X=main.version=v1 beta", `-extld=C:\Program Files\gcc\bin\gcc.exe`, `$WORK\b001\_pkg_.a`},
+		},
+		{
+			name:      "forward slashes",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      "C:/Go/pkg/tool/windows_amd64/link.exe -o $WORK/b001/exe/a.out.exe -importcfg $WORK/b001/importcfg.link $WORK/b001/_pkg_.a",
+			want:      []string{"-o", "$WORK/b001/exe/a.out.exe", "-importcfg", "$WORK/b001/importcfg.link", "$WORK/b001/_pkg_.a"},
+		},
+		{
+			name:      "other GOROOT",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"D:\\sdk\\go1.27.1\\pkg\\tool\\windows_amd64\\link.exe" -o "$WORK\\b001\\exe\\a.out.exe" -importcfg "$WORK\\b001\\importcfg.link" "$WORK\\b001\\_pkg_.a"`,
+			want:      []string{"-o", `$WORK\b001\exe\a.out.exe`, "-importcfg", `$WORK\b001\importcfg.link`, `$WORK\b001\_pkg_.a`},
+		},
+		{
+			name:      "linker outside of GOTOOLDIR",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"C:\\toolchains\\bin\\link.exe" -o "$WORK\\b001\\exe\\a.out.exe" -importcfg "$WORK\\b001\\importcfg.link" -buildmode=exe "$WORK\\b001\\_pkg_.a"`,
+			want:      []string{"-o", `$WORK\b001\exe\a.out.exe`, "-importcfg", `$WORK\b001\importcfg.link`, "-buildmode=exe", `$WORK\b001\_pkg_.a`},
+		},
+		{
+			name:      "linker outside of GOTOOLDIR after cd",
+			goToolDir: "/usr/local/go/pkg/tool/linux_amd64",
+			line:      "cd $WORK/b001 && GOROOT='/opt/go' /opt/toolchains/bin/link -o a.out -importcfg=importcfg.link -buildmode=exe -buildid=abc/def _pkg_.a",
+			want:      []string{"-o", "a.out", "-importcfg=importcfg.link", "-buildmode=exe", "-buildid=abc/def", "_pkg_.a"},
+		},
+		{
+			name:      "compiler of a package named link",
+			goToolDir: "/usr/local/go/pkg/tool/linux_amd64",
+			line:      `/opt/toolchains/bin/compile -o $WORK/b002/_pkg_.a -trimpath "$WORK/b002=>" -p github.com/acme/link -lang=go1.23 -complete -buildid abc/def -goversion go1.23.4 -c=4 -nolocalimports -importcfg $WORK/b002/importcfg -pack ./link.go`,
+		},
+		{
+			name:      "linker outside of GOTOOLDIR without buildmode",
+			goToolDir: "/usr/local/go/pkg/tool/linux_amd64",
+			line:      "/opt/toolchains/bin/link -o a.out -importcfg importcfg.link _pkg_.a",
+		},
+		{
+			name:      "compiler",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"C:\\Go\\pkg\\tool\\windows_amd64\\compile.exe" -o "$WORK\\b001\\_pkg_.a" -trimpath "$WORK\\b001=>" -p main -importcfg "$WORK\\b001\\importcfg" "C:\\src\\hello\\main.go"`,
+		},
+		{
+			name:      "linker without importcfg outside of GOTOOLDIR",
+			goToolDir: `C:\Go\pkg\tool\windows_amd64`,
+			line:      `"C:\\toolchains\\bin\\link.exe" -V=full`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := linkCommandRegexp(tt.goToolDir).FindStringSubmatch(tt.line)
+			if matches == nil {
+				matches = anyLinkCommandRe.FindStringSubmatch(tt.line)
+			}
+
+			var got []string
+			if matches != nil {
+				var err error
+				if got, err = splitShellWords(matches[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("link command args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// PruneBinary deletes all the link commands of a binary, whatever their build
+// tags and platform, and garbage collects the package files that aren’t
+// referenced anymore.
+func PruneBinary(ctx context.Context, db *sql.DB, binaryName string) (prunedLinkCommands, prunedPackageFiles int64, err error) {
+	return prune(ctx, db, `SELECT link_command_id FROM link_command WHERE binary_name = ?`, binaryName)
+}
+
+// PruneLinkCommand deletes the link commands of a binary built with the given
+// build tags and garbage collects the package files that aren’t referenced
+// anymore.
+func PruneLinkCommand(ctx context.Context, db *sql.DB, binaryName string, buildTags []string) (prunedLinkCommands, prunedPackageFiles int64, err error) {
+	return prune(ctx, db, `SELECT link_command_id FROM link_command NATURAL JOIN build_tags WHERE binary_name = ? AND canonical = ?`, binaryName, CanonicalBuildTags(buildTags))
+}
+
+// PruneOrphans garbage collects the package files, importcfg lines and build
+// tags that aren’t referenced by any link command.
+func PruneOrphans(ctx context.Context, db *sql.DB) (prunedPackageFiles int64, err error) {
+	_, prunedPackageFiles, err = prune(ctx, db, "")
+	return prunedPackageFiles, err
+}
+
+// prune deletes the link commands whose IDs are returned by the selection
+// query, then the rows that aren’t referenced anymore.
+func prune(ctx context.Context, db *sql.DB, selection string, args ...any) (prunedLinkCommands, prunedPackageFiles int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to rollback transaction: %w", err2))
+			}
+			return
+		}
+		if err2 := tx.Commit(); err2 != nil {
+			err = fmt.Errorf("unable to commit transaction: %w", err2)
+		}
+	}()
+
+	if selection != "" {
+		for _, table := range []string{"link_command_package_file", "importcfg_additional_lines", "link_command"} {
+			result, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_command_id IN (`+selection+`);`, args...)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unable to delete from %s: %w", table, err)
+			}
+			if table == "link_command" {
+				if prunedLinkCommands, err = result.RowsAffected(); err != nil {
+					return 0, 0, fmt.Errorf("unable to count deleted link commands: %w", err)
+				}
+			}
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+DELETE FROM package_file
+WHERE package_file_id NOT IN (SELECT package_file_id FROM link_command_package_file)
+	AND package_file_id NOT IN (SELECT main_package_id FROM link_command WHERE main_package_id IS NOT NULL);`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to delete orphan package files: %w", err)
+	}
+	if prunedPackageFiles, err = result.RowsAffected(); err != nil {
+		return 0, 0, fmt.Errorf("unable to count deleted package files: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM importcfg_line WHERE importcfg_line_id NOT IN (SELECT importcfg_line_id FROM importcfg_additional_lines);`); err != nil {
+		return 0, 0, fmt.Errorf("unable to delete orphan importcfg lines: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM build_tags WHERE build_tags_id NOT IN (SELECT build_tags_id FROM link_command);`); err != nil {
+		return 0, 0, fmt.Errorf("unable to delete orphan build tags: %w", err)
+	}
+
+	return prunedLinkCommands, prunedPackageFiles, nil
+}
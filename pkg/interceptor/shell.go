@@ -0,0 +1,90 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// splitShellWords splits a command line into words following the POSIX shell
+// quoting rules: single quotes, double quotes and backslash escapes.
+// Empty quoted strings are preserved as empty words.
+func splitShellWords(line string) (words []string, err error) {
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ' ', '\t', '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\\':
+			i++
+			if i >= len(line) {
+				return nil, fmt.Errorf("trailing backslash in %q", line)
+			}
+			if line[i] != '\n' {
+				word.WriteByte(line[i])
+				inWord = true
+			}
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", line)
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) >= 0 {
+					i++
+					if line[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote in %q", line)
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// shellWrapperRe matches a command run by a shell with `sh -c`.
+var shellWrapperRe = regexp.MustCompile(`^(?:\S*[/\\])?(?:ba|da)?sh +-c +`)
+
+// unwrapShellCommand returns the command run by `sh -c 'command'`, or line
+// itself when it isn’t wrapped.
+func unwrapShellCommand(line string) (string, error) {
+	if !shellWrapperRe.MatchString(line) {
+		return line, nil
+	}
+
+	words, err := splitShellWords(line)
+	if err != nil {
+		return "", fmt.Errorf("unable to split shell invocation: %w", err)
+	}
+	if len(words) < 3 {
+		return "", fmt.Errorf("missing command in shell invocation %q", line)
+	}
+
+	return words[2], nil
+}
@@ -0,0 +1,1043 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/L3n41c/golinkinterceptor/internal/sqlite"
+)
+
+// MemoryDB is the database path of a database kept in memory, which is lost
+// when it is closed.
+const MemoryDB = ":memory:"
+
+// OpenDB opens the database, creating it if needed, and upgrades its schema to
+// the latest version.
+// The database is opened in WAL journal mode and waits up to busyTimeout for
+// the locks held by concurrent writers.
+func OpenDB(ctx context.Context, dbPath string, busyTimeout time.Duration) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?mode=rwc&_foreign_keys=true&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, busyTimeout.Milliseconds())
+	if dbPath == MemoryDB {
+		dsn = "file::memory:?_foreign_keys=true&_txlock=immediate"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database %q: %w", dbPath, err)
+	}
+	if dbPath == MemoryDB {
+		// Each connection to `:memory:` has its own empty database
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+
+	err = migrateDB(ctx, db)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("unable to migrate database %q: %w", dbPath, err), db.Close())
+	}
+
+	return db, nil
+}
+
+// migrations are the ordered steps creating and upgrading the database schema.
+// The schema version stored in `PRAGMA user_version` is the number of
+// migrations already applied to the database.
+// Databases created before the schema was versioned have version 0 but already
+// contain the tables of the first migration, hence the `IF NOT EXISTS`.
+var migrations = [][]string{
+	// Version 1: initial schema
+	{
+		`
+CREATE TABLE IF NOT EXISTS link_command (
+	link_command_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	binary_name     TEXT    NOT NULL,
+	build_tags_id   INTEGER NOT NULL,
+	main_package_id INTEGER,
+	UNIQUE (binary_name, build_tags_id),
+	FOREIGN KEY (build_tags_id) REFERENCES build_tags(build_tags_id),
+	FOREIGN KEY (main_package_id) REFERENCES package_file(package_file_id)
+);`,
+		`
+CREATE TABLE IF NOT EXISTS link_command_args (
+	link_command_id INTEGER NOT NULL,
+	pos             INTEGER NOT NULL,
+	arg             TEXT    NOT NULL,
+	PRIMARY KEY (link_command_id, pos),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id)
+);`,
+		`
+CREATE TABLE IF NOT EXISTS build_tags (
+	build_tags_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tags          JSONB NOT NULL UNIQUE
+);`,
+		`
+CREATE TABLE IF NOT EXISTS package_file (
+	package_file_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	package         TEXT    NOT NULL,
+	file            TEXT    NOT NULL UNIQUE
+);`,
+		`
+CREATE TABLE IF NOT EXISTS link_command_package_file (
+	link_command_id INTEGER NOT NULL,
+	package_file_id INTEGER NOT NULL,
+	PRIMARY KEY (link_command_id, package_file_id),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id),
+	FOREIGN KEY (package_file_id) REFERENCES package_file(package_file_id)
+);`,
+		`
+CREATE TABLE IF NOT EXISTS importcfg_additional_lines (
+	link_command_id INTEGER NOT NULL,
+	line            TEXT    NOT NULL,
+	PRIMARY KEY (link_command_id, line),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id)
+);`,
+	},
+	// Version 2: link commands are keyed by target platform
+	// Link commands captured before are assumed to target the host platform.
+	{
+		`
+CREATE TABLE link_command_v2 (
+	link_command_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	binary_name     TEXT    NOT NULL,
+	build_tags_id   INTEGER NOT NULL,
+	goos            TEXT    NOT NULL,
+	goarch          TEXT    NOT NULL,
+	main_package_id INTEGER,
+	UNIQUE (binary_name, build_tags_id, goos, goarch),
+	FOREIGN KEY (build_tags_id) REFERENCES build_tags(build_tags_id),
+	FOREIGN KEY (main_package_id) REFERENCES package_file(package_file_id)
+);`,
+		`
+INSERT INTO link_command_v2 (link_command_id, binary_name, build_tags_id, goos, goarch, main_package_id)
+SELECT link_command_id, binary_name, build_tags_id, '` + runtime.GOOS + `', '` + runtime.GOARCH + `', main_package_id
+FROM link_command;`,
+		`DROP TABLE link_command;`,
+		`ALTER TABLE link_command_v2 RENAME TO link_command;`,
+	},
+	// Version 3: build flags the link commands were produced with
+	// They are unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN build_flags JSONB;`,
+	},
+	// Version 4: Go toolchain version the link commands were captured with
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN go_version TEXT;`,
+	},
+	// Version 5: module mode (`-mod` build flag) the link commands were
+	// captured with
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN mod_mode TEXT;`,
+	},
+	// Version 6: the order of the importcfg additional lines is preserved
+	// Lines captured before are numbered in their insertion order.
+	{
+		`
+CREATE TABLE importcfg_additional_lines_v6 (
+	link_command_id INTEGER NOT NULL,
+	pos             INTEGER NOT NULL,
+	line            TEXT    NOT NULL,
+	PRIMARY KEY (link_command_id, pos),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id)
+);`,
+		`
+INSERT INTO importcfg_additional_lines_v6 (link_command_id, pos, line)
+SELECT link_command_id, row_number() OVER (PARTITION BY link_command_id ORDER BY rowid), line
+FROM importcfg_additional_lines;`,
+		`DROP TABLE importcfg_additional_lines;`,
+		`ALTER TABLE importcfg_additional_lines_v6 RENAME TO importcfg_additional_lines;`,
+	},
+	// Version 7: build mode of the link commands
+	// It is recovered from the arguments of the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN buildmode TEXT NOT NULL DEFAULT 'exe';`,
+		`
+UPDATE link_command
+SET buildmode = (
+	SELECT substr(arg, length('-buildmode=') + 1)
+	FROM link_command_args
+	WHERE link_command_args.link_command_id = link_command.link_command_id
+		AND arg LIKE '-buildmode=%'
+)
+WHERE EXISTS (
+	SELECT 1
+	FROM link_command_args
+	WHERE link_command_args.link_command_id = link_command.link_command_id
+		AND arg LIKE '-buildmode=%'
+);`,
+	},
+	// Version 8: the arguments of the link commands are stored as a JSON array
+	// instead of one row each, which is much faster to write
+	{
+		`ALTER TABLE link_command ADD COLUMN args JSONB NOT NULL DEFAULT '[]';`,
+		`
+UPDATE link_command
+SET args = (
+	SELECT jsonb_group_array(arg ORDER BY pos)
+	FROM link_command_args
+	WHERE link_command_args.link_command_id = link_command.link_command_id
+)
+WHERE EXISTS (
+	SELECT 1
+	FROM link_command_args
+	WHERE link_command_args.link_command_id = link_command.link_command_id
+);`,
+		`DROP TABLE link_command_args;`,
+	},
+	// Version 9: link commands are keyed by instrumentation (`-race`, `-msan`,
+	// `-asan`) as instrumented builds link other package files
+	// It is recovered from the arguments of the link commands captured before.
+	{
+		`
+CREATE TABLE link_command_v9 (
+	link_command_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	binary_name     TEXT    NOT NULL,
+	build_tags_id   INTEGER NOT NULL,
+	goos            TEXT    NOT NULL,
+	goarch          TEXT    NOT NULL,
+	instrumentation TEXT    NOT NULL DEFAULT '',
+	main_package_id INTEGER,
+	build_flags     JSONB,
+	go_version      TEXT,
+	mod_mode        TEXT,
+	buildmode       TEXT    NOT NULL DEFAULT 'exe',
+	args            JSONB   NOT NULL DEFAULT '[]',
+	UNIQUE (binary_name, build_tags_id, goos, goarch, instrumentation),
+	FOREIGN KEY (build_tags_id) REFERENCES build_tags(build_tags_id),
+	FOREIGN KEY (main_package_id) REFERENCES package_file(package_file_id)
+);`,
+		`
+INSERT INTO link_command_v9 (link_command_id, binary_name, build_tags_id, goos, goarch, instrumentation, main_package_id, build_flags, go_version, mod_mode, buildmode, args)
+SELECT link_command_id, binary_name, build_tags_id, goos, goarch, (
+	SELECT coalesce(group_concat(substr(value, 2), ',' ORDER BY value), '')
+	FROM json_each(link_command.args)
+	WHERE value IN ('-asan', '-msan', '-race')
+), main_package_id, build_flags, go_version, mod_mode, buildmode, args
+FROM link_command;`,
+		`DROP TABLE link_command;`,
+		`ALTER TABLE link_command_v9 RENAME TO link_command;`,
+	},
+	// Version 10: `CGO_ENABLED` during the build of the link commands
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN cgo_enabled TEXT;`,
+	},
+	// Version 11: temporary work directory of the build of the link commands
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN work_dir TEXT;`,
+	},
+	// Version 12: working directory of the build of the link commands and
+	// absolute path of their binaries
+	// Only the path of the binaries with an absolute name is known for the
+	// link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN build_dir TEXT;`,
+		`ALTER TABLE link_command ADD COLUMN binary_path TEXT;`,
+		// POSIX, Windows drive and UNC absolute paths
+		`UPDATE link_command SET binary_path = binary_name WHERE binary_name LIKE '/%' OR binary_name LIKE '_:\%' OR binary_name LIKE '_:/%' OR binary_name LIKE '\\%';`,
+		`CREATE INDEX IF NOT EXISTS link_command_binary_path ON link_command(binary_path);`,
+	},
+	// Version 13: hash of the importcfg of the link commands
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN importcfg_hash TEXT;`,
+	},
+	// Version 14: environment variables of the Go toolchain during the build
+	// They are unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN env JSONB;`,
+	},
+	// Version 15: line of the build output running the linker, kept for
+	// reference. It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN command TEXT;`,
+	},
+	// Version 16: the importcfg additional lines are stored once, keyed by
+	// their hash, as link commands of the same module share their large
+	// `modinfo` line
+	// The lines stored before are hashed by hashImportcfgLines.
+	{
+		`
+CREATE TABLE importcfg_line (
+	importcfg_line_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash              TEXT    UNIQUE,
+	line              TEXT    NOT NULL
+);`,
+		`INSERT INTO importcfg_line (line) SELECT DISTINCT line FROM importcfg_additional_lines;`,
+		`
+CREATE TABLE importcfg_additional_lines_v16 (
+	link_command_id   INTEGER NOT NULL,
+	pos               INTEGER NOT NULL,
+	importcfg_line_id INTEGER NOT NULL,
+	PRIMARY KEY (link_command_id, pos),
+	FOREIGN KEY (link_command_id) REFERENCES link_command(link_command_id),
+	FOREIGN KEY (importcfg_line_id) REFERENCES importcfg_line(importcfg_line_id)
+);`,
+		`
+INSERT INTO importcfg_additional_lines_v16 (link_command_id, pos, importcfg_line_id)
+SELECT link_command_id, pos, importcfg_line_id
+FROM importcfg_additional_lines
+JOIN importcfg_line USING (line);`,
+		`DROP TABLE importcfg_additional_lines;`,
+		`ALTER TABLE importcfg_additional_lines_v16 RENAME TO importcfg_additional_lines;`,
+	},
+	// Version 17: build tags are keyed by their canonical form, sorted,
+	// deduplicated and comma-separated, as equivalent JSON arrays like `null`
+	// and `[]` didn’t match
+	// The build tags stored before are canonicalized by canonicalizeBuildTags.
+	{
+		`
+CREATE TABLE build_tags_v17 (
+	build_tags_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	canonical     TEXT    UNIQUE,
+	tags          JSONB   NOT NULL
+);`,
+		`INSERT INTO build_tags_v17 (build_tags_id, tags) SELECT build_tags_id, tags FROM build_tags;`,
+		`DROP TABLE build_tags;`,
+		`ALTER TABLE build_tags_v17 RENAME TO build_tags;`,
+	},
+	// Version 18: time the link commands were last stored at, to select the
+	// latest of those matching a lookup
+	// It is unknown for the link commands captured before.
+	{
+		`ALTER TABLE link_command ADD COLUMN created_at TEXT;`,
+	},
+	// Version 19: time the link commands were first and last stored at
+	// created_at was the time they were last stored at.
+	{
+		`ALTER TABLE link_command ADD COLUMN updated_at TEXT;`,
+		`UPDATE link_command SET updated_at = created_at;`,
+	},
+}
+
+// migrateDB upgrades the database schema to the latest version.
+func migrateDB(ctx context.Context, db *sql.DB) (err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get database connection: %w", err)
+	}
+	defer func() {
+		if err2 := conn.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close database connection: %w", err2))
+		}
+	}()
+
+	if upToDate, err := isSchemaUpToDate(ctx, conn); err != nil || upToDate {
+		return err
+	}
+
+	// Tables are rebuilt by some migrations, which requires foreign keys to be
+	// disabled. This can’t be done inside a transaction.
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF;`); err != nil {
+		return fmt.Errorf("unable to disable foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err2 := conn.ExecContext(ctx, `PRAGMA foreign_keys = ON;`); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to enable foreign keys: %w", err2))
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if err2 := tx.Rollback(); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to rollback transaction: %w", err2))
+			}
+			return
+		}
+		if err2 := tx.Commit(); err2 != nil {
+			err = fmt.Errorf("unable to commit transaction: %w", err2)
+		}
+	}()
+
+	// A concurrent interceptor may have migrated the schema in the meantime
+	var version int
+	if err := tx.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		return fmt.Errorf("unable to get schema version: %w", err)
+	}
+
+	for ; version < len(migrations); version++ {
+		Logger.Info("Migrating database schema", "version", version+1)
+		for _, sqlStmt := range migrations[version] {
+			if _, err := tx.ExecContext(ctx, sqlStmt); err != nil {
+				return fmt.Errorf("unable to migrate schema to version %d: %w", version+1, err)
+			}
+		}
+	}
+	if err := hashImportcfgLines(ctx, tx); err != nil {
+		return fmt.Errorf("unable to migrate schema to version %d: %w", version, err)
+	}
+	if err := canonicalizeBuildTags(ctx, tx); err != nil {
+		return fmt.Errorf("unable to migrate schema to version %d: %w", version, err)
+	}
+
+	var violations int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM pragma_foreign_key_check;`).Scan(&violations); err != nil {
+		return fmt.Errorf("unable to check foreign keys: %w", err)
+	}
+	if violations > 0 {
+		return fmt.Errorf("schema migration to version %d would violate %d foreign key constraints", version, violations)
+	}
+
+	// PRAGMA statements don’t support bound parameters
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d;`, version)); err != nil {
+		return fmt.Errorf("unable to set schema version: %w", err)
+	}
+
+	return nil
+}
+
+// ImportcfgHash returns the hex-encoded SHA-256 of the sorted lines of an
+// importcfg, which doesn’t depend on the order the lines are stored in.
+func ImportcfgHash(lines []string) string {
+	h := sha256.New()
+	for _, line := range slices.Sorted(slices.Values(lines)) {
+		h.Write([]byte(line + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lineHash returns the hex-encoded SHA-256 of an importcfg line, the key it is
+// stored under.
+func lineHash(line string) string {
+	h := sha256.Sum256([]byte(line))
+	return hex.EncodeToString(h[:])
+}
+
+// hashImportcfgLines sets the hash of the importcfg lines stored before they
+// were keyed by it, which SQLite can’t compute.
+func hashImportcfgLines(ctx context.Context, tx *sql.Tx) (err error) {
+	rows, err := tx.QueryContext(ctx, `SELECT importcfg_line_id, line FROM importcfg_line WHERE hash IS NULL;`)
+	if err != nil {
+		return fmt.Errorf("unable to query unhashed importcfg lines: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close importcfg lines rows: %w", err2))
+		}
+	}()
+
+	hashes := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var line string
+		if err := rows.Scan(&id, &line); err != nil {
+			return fmt.Errorf("unable to scan importcfg line: %w", err)
+		}
+		hashes[id] = lineHash(line)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading importcfg lines rows: %w", err)
+	}
+
+	for id, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `UPDATE importcfg_line SET hash = ? WHERE importcfg_line_id = ?;`, hash, id); err != nil {
+			return fmt.Errorf("unable to set importcfg line hash: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// canonicalizeBuildTags sets the canonical form of the build tags stored before
+// they were keyed by it. The equivalent build tags are merged, keeping the most
+// recently stored of the link commands they both have.
+func canonicalizeBuildTags(ctx context.Context, tx *sql.Tx) (err error) {
+	rows, err := tx.QueryContext(ctx, `SELECT build_tags_id, json(tags) FROM build_tags WHERE canonical IS NULL ORDER BY build_tags_id;`)
+	if err != nil {
+		return fmt.Errorf("unable to query uncanonicalized build tags: %w", err)
+	}
+	defer func() {
+		if err2 := rows.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close build tags rows: %w", err2))
+		}
+	}()
+
+	type buildTags struct {
+		id   int64
+		tags []string
+	}
+	var uncanonicalized []buildTags
+	for rows.Next() {
+		var t buildTags
+		var tagsJSON string
+		if err := rows.Scan(&t.id, &tagsJSON); err != nil {
+			return fmt.Errorf("unable to scan build tags: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tagsJSON), &t.tags); err != nil {
+			return fmt.Errorf("unable to unmarshal build tags: %w", err)
+		}
+		uncanonicalized = append(uncanonicalized, t)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading build tags rows: %w", err)
+	}
+
+	merged := false
+	for _, t := range uncanonicalized {
+		canonical := CanonicalBuildTags(t.tags)
+		var id int64
+		err := tx.QueryRowContext(ctx, `SELECT build_tags_id FROM build_tags WHERE canonical = ?;`, canonical).Scan(&id)
+		switch {
+		case err == sql.ErrNoRows:
+			buildTagsJSON, err := json.Marshal(ParseBuildTags(canonical))
+			if err != nil {
+				return fmt.Errorf("unable to marshal build tags: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE build_tags SET canonical = ?, tags = jsonb(?) WHERE build_tags_id = ?;`, canonical, buildTagsJSON, t.id); err != nil {
+				return fmt.Errorf("unable to set canonical build tags: %w", err)
+			}
+		case err != nil:
+			return fmt.Errorf("unable to get build tags ID: %w", err)
+		default:
+			if _, err := tx.ExecContext(ctx, `
+DELETE FROM link_command AS old
+WHERE build_tags_id IN (?, ?) AND EXISTS (
+	SELECT 1
+	FROM link_command AS new
+	WHERE new.build_tags_id IN (?, ?) AND new.binary_name = old.binary_name AND new.goos = old.goos AND new.goarch = old.goarch
+		AND new.instrumentation = old.instrumentation AND new.link_command_id > old.link_command_id
+);`, id, t.id, id, t.id); err != nil {
+				return fmt.Errorf("unable to delete replaced link commands: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE link_command SET build_tags_id = ? WHERE build_tags_id = ?;`, id, t.id); err != nil {
+				return fmt.Errorf("unable to merge build tags: %w", err)
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM build_tags WHERE build_tags_id = ?;`, t.id); err != nil {
+				return fmt.Errorf("unable to delete merged build tags: %w", err)
+			}
+			merged = true
+		}
+	}
+	if !merged {
+		return nil
+	}
+
+	// The link commands deleted while merging leave their rows behind
+	if err := deleteLinkCommandRows(ctx, tx, linkCommandRowTables, `NOT IN (SELECT link_command_id FROM link_command)`); err != nil {
+		return fmt.Errorf("unable to delete rows of replaced link commands: %w", err)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the latest version of the database schema.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// isSchemaUpToDate reports whether the database schema is at the latest
+// version and fails if it is newer than the supported one.
+func isSchemaUpToDate(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+		return false, fmt.Errorf("unable to get schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return false, fmt.Errorf("database schema version %d is newer than the latest supported version %d, please upgrade the interceptor", version, len(migrations))
+	}
+
+	return version == len(migrations), nil
+}
+
+// storeAttempts is the number of times a transaction is attempted when the
+// database is locked by concurrent writers beyond the busy timeout.
+const storeAttempts = 5
+
+// Store writes the link commands of the build result into the database.
+// A link command already stored for the same binary, build tags, platform and
+// instrumentation is updated, keeping its ID and creation time. If force is
+// set, it’s deleted along with its rows and inserted again instead, getting a
+// new ID and creation time as if it were stored for the first time.
+// A *PartialStoreError is returned when only some link commands are stored.
+func Store(ctx context.Context, db *sql.DB, result *BuildResult, force bool) error {
+	for attempt := 1; ; attempt++ {
+		err := store(ctx, db, result, force)
+		if attempt < storeAttempts && sqlite.IsBusy(err) {
+			Logger.Info("Database is busy, retrying", "attempt", attempt+1, "attempts", storeAttempts)
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			}
+			continue
+		}
+		return err
+	}
+}
+
+func store(ctx context.Context, db *sql.DB, result *BuildResult, force bool) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer func() {
+		// The link commands stored are committed even if others failed
+		if _, partial := err.(*PartialStoreError); err != nil && !partial { //nolint:errorlint
+			if err2 := tx.Rollback(); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to rollback transaction: %w", err2))
+			}
+			return
+		}
+		if err2 := tx.Commit(); err2 != nil {
+			err = fmt.Errorf("unable to commit transaction: %w", err2)
+		}
+	}()
+
+	stmts, err := prepareStatements(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("unable to prepare statements: %w", err)
+	}
+	defer func() {
+		if err2 := stmts.Close(); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to close prepared statements: %w", err2))
+		}
+	}()
+
+	buildTagsID, err := insertBuildTags(ctx, tx, result.BuildTags)
+	if err != nil {
+		return fmt.Errorf("unable to insert build tags into database: %w", err)
+	}
+
+	// A link command that can’t be stored is rolled back alone, so that the
+	// other binaries of the build are still stored
+	var failedBinaries []string
+	var errs []error
+	for _, linkCommand := range result.LinkCommands {
+		err := withSavepoint(ctx, tx, func() error {
+			return storeLinkCommand(ctx, tx, stmts, result, linkCommand, buildTagsID, force)
+		})
+		if err != nil && (ctx.Err() != nil || sqlite.IsBusy(err)) {
+			return err
+		}
+		if err != nil {
+			Logger.Warn("Unable to store link command", "binary", linkCommand.BinaryName, "error", err)
+			failedBinaries = append(failedBinaries, linkCommand.BinaryName)
+			errs = append(errs, err)
+		}
+	}
+	if len(failedBinaries) > 0 {
+		return &PartialStoreError{Binaries: failedBinaries, Stored: len(result.LinkCommands) - len(failedBinaries), Err: errors.Join(errs...)}
+	}
+
+	return nil
+}
+
+// PartialStoreError is returned by Store when some link commands couldn’t be
+// stored. The other ones are stored nonetheless.
+type PartialStoreError struct {
+	Binaries []string // Names of the binaries whose link command isn’t stored
+	Stored   int      // Number of link commands stored
+	Err      error    // Why the link commands of Binaries aren’t stored
+}
+
+func (e *PartialStoreError) Error() string {
+	return fmt.Sprintf("unable to store the link commands of %s (%d of %d stored): %v", strings.Join(e.Binaries, ", "), e.Stored, e.Stored+len(e.Binaries), e.Err)
+}
+
+func (e *PartialStoreError) Unwrap() error {
+	return e.Err
+}
+
+// withSavepoint runs f within a savepoint of tx, rolled back if f fails.
+func withSavepoint(ctx context.Context, tx *sql.Tx, f func() error) (err error) {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT link_command;`); err != nil {
+		return fmt.Errorf("unable to create savepoint: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if _, err2 := tx.ExecContext(ctx, `ROLLBACK TO link_command;`); err2 != nil {
+				err = errors.Join(err, fmt.Errorf("unable to rollback to savepoint: %w", err2))
+			}
+		}
+		if _, err2 := tx.ExecContext(ctx, `RELEASE link_command;`); err2 != nil {
+			err = errors.Join(err, fmt.Errorf("unable to release savepoint: %w", err2))
+		}
+	}()
+
+	return f()
+}
+
+func storeLinkCommand(ctx context.Context, tx *sql.Tx, stmts *statements, result *BuildResult, linkCommand LinkCommand, buildTagsID int64, force bool) error {
+	if force {
+		if err := deleteLinkCommand(ctx, tx, result, linkCommand, buildTagsID); err != nil {
+			return fmt.Errorf("unable to delete existing link command from database: %w", err)
+		}
+	}
+
+	linkCommandID, importcfg, err := insertLinkCommand(ctx, tx, result, linkCommand, buildTagsID)
+	if err != nil {
+		return fmt.Errorf("unable to insert link command into database: %w", err)
+	}
+
+	var packageFiles []string
+	for pos, line := range result.Files[importcfg] {
+		if strings.HasPrefix(line, "packagefile") {
+			packageFiles = append(packageFiles, line)
+		} else {
+			if err := insertAdditionalLines(ctx, stmts, linkCommandID, pos, line); err != nil {
+				return fmt.Errorf("unable to insert additional lines into database: %w", err)
+			}
+		}
+	}
+	if err := insertPackageFiles(ctx, tx, stmts, linkCommandID, packageFiles); err != nil {
+		return fmt.Errorf("unable to insert package files into database: %w", err)
+	}
+
+	mainPos, mainFile, err := mainPackageArg(linkCommand.Args, packageFiles, linkCommand.MainPackage)
+	if err != nil {
+		return fmt.Errorf("unable to find main package of %s: %w", linkCommand.BinaryName, err)
+	}
+	if err := updateLinkCommand(ctx, tx, linkCommandID, mainPos, mainFile); err != nil {
+		return fmt.Errorf("unable to update link command in database: %w", err)
+	}
+
+	return nil
+}
+
+// packageFilesBatchSize is the number of package files inserted by a single
+// statement. Large binaries link more than a thousand packages.
+const packageFilesBatchSize = 256
+
+// statements are the prepared statements executed repeatedly for every link
+// command.
+type statements struct {
+	insertPackageFiles            *sql.Stmt // Inserts a full batch of package files
+	insertLinkCommandPackageFiles *sql.Stmt // Associates a full batch of package files
+	insertImportcfgLine           *sql.Stmt // Inserts a line unless already stored
+	insertAdditionalLine          *sql.Stmt
+}
+
+// packageFilesQueries returns the queries inserting n package files and
+// associating them to a link command.
+func packageFilesQueries(n int) (insertPackageFiles, insertLinkCommandPackageFiles string) {
+	return `INSERT INTO package_file (package, file) VALUES ` + strings.Repeat(`(?, ?), `, n-1) + `(?, ?) ON CONFLICT (file) DO NOTHING;`,
+		`INSERT INTO link_command_package_file (link_command_id, package_file_id) SELECT ?, package_file_id FROM package_file WHERE file IN (` + strings.Repeat(`?, `, n-1) + `?);`
+}
+
+func prepareStatements(ctx context.Context, tx *sql.Tx) (stmts *statements, err error) {
+	stmts = &statements{}
+	insertPackageFiles, insertLinkCommandPackageFiles := packageFilesQueries(packageFilesBatchSize)
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.insertPackageFiles, insertPackageFiles},
+		{&stmts.insertLinkCommandPackageFiles, insertLinkCommandPackageFiles},
+		{&stmts.insertImportcfgLine, `INSERT INTO importcfg_line (hash, line) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING;`},
+		{&stmts.insertAdditionalLine, `INSERT INTO importcfg_additional_lines (link_command_id, pos, importcfg_line_id) SELECT ?, ?, importcfg_line_id FROM importcfg_line WHERE hash = ?;`},
+	} {
+		if *s.stmt, err = tx.PrepareContext(ctx, s.query); err != nil {
+			return nil, errors.Join(fmt.Errorf("unable to prepare statement %q: %w", s.query, err), stmts.Close())
+		}
+	}
+
+	return stmts, nil
+}
+
+func (stmts *statements) Close() (err error) {
+	for _, stmt := range []*sql.Stmt{
+		stmts.insertPackageFiles,
+		stmts.insertLinkCommandPackageFiles,
+		stmts.insertImportcfgLine,
+		stmts.insertAdditionalLine,
+	} {
+		if stmt != nil {
+			err = errors.Join(err, stmt.Close())
+		}
+	}
+
+	return err
+}
+
+func insertBuildTags(ctx context.Context, tx *sql.Tx, buildTags []string) (int64, error) {
+	// The tags are looked up by their canonical form, their JSON array is
+	// only kept for readability
+	canonical := CanonicalBuildTags(buildTags)
+	buildTagsJSON, err := json.Marshal(ParseBuildTags(canonical))
+	if err != nil {
+		return 0, fmt.Errorf("unable to marshal build tags: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO build_tags (canonical, tags) VALUES (?, jsonb(?)) ON CONFLICT DO NOTHING;`, canonical, buildTagsJSON)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert build tags: %w", err)
+	}
+
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 1 {
+		if lastInsertID, err := result.LastInsertId(); err == nil {
+			return lastInsertID, nil
+		}
+	}
+
+	row := tx.QueryRowContext(ctx, `SELECT build_tags_id FROM build_tags WHERE canonical = ?;`, canonical)
+	var buildTagsID int64
+	if err := row.Scan(&buildTagsID); err != nil {
+		return 0, fmt.Errorf("unable to get build tags ID: %w", err)
+	}
+
+	return buildTagsID, nil
+}
+
+func insertLinkCommand(ctx context.Context, tx *sql.Tx, result *BuildResult, linkCommand LinkCommand, buildTagsID int64) (int64, string, error) {
+	buildFlagsJSON, err := json.Marshal(result.BuildFlags)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to marshal build flags: %w", err)
+	}
+	envJSON, err := json.Marshal(result.Env)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to marshal environment: %w", err)
+	}
+
+	var importcfg string
+	args := make([]string, len(linkCommand.Args))
+	var prevArg string
+	// The executor substitutes the value of every occurrence of the flags
+	flagCounts := make(map[string]int)
+	for i, arg := range linkCommand.Args {
+		// Flags can be given either as `-flag value` or as `-flag=value`
+		switch {
+		case prevArg == "-o":
+			flagCounts["-o"]++
+			arg = "PLACEHOLDER"
+		case prevArg == "-importcfg":
+			flagCounts["-importcfg"]++
+			importcfg = arg
+			arg = "PLACEHOLDER"
+		case strings.HasPrefix(arg, "-o="):
+			flagCounts["-o"]++
+			arg = "-o=PLACEHOLDER"
+		case strings.HasPrefix(arg, "-importcfg="):
+			flagCounts["-importcfg"]++
+			importcfg = strings.TrimPrefix(arg, "-importcfg=")
+			arg = "-importcfg=PLACEHOLDER"
+		}
+		args[i] = arg
+		prevArg = arg
+	}
+	for _, flag := range []string{"-o", "-importcfg"} {
+		if flagCounts[flag] > 1 {
+			return 0, "", fmt.Errorf("link command has %d %s flags instead of one, the build output may be malformed: %s", flagCounts[flag], flag, strings.Join(linkCommand.Args, " "))
+		}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to marshal link command arguments: %w", err)
+	}
+
+	// A binary intercepted again replaces its previous link command
+	var linkCommandID int64
+	row := tx.QueryRowContext(ctx, `
+INSERT INTO link_command (binary_name, build_tags_id, goos, goarch, instrumentation, go_version, build_flags, mod_mode, buildmode, args, cgo_enabled, work_dir, build_dir, binary_path, importcfg_hash, env, command, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, jsonb(?), ?, ?, jsonb(?), ?, ?, ?, ?, ?, jsonb(?), ?, strftime('%Y-%m-%dT%H:%M:%fZ'), strftime('%Y-%m-%dT%H:%M:%fZ'))
+ON CONFLICT (binary_name, build_tags_id, goos, goarch, instrumentation) DO UPDATE
+SET go_version = excluded.go_version, build_flags = excluded.build_flags, mod_mode = excluded.mod_mode, buildmode = excluded.buildmode, args = excluded.args, cgo_enabled = excluded.cgo_enabled, work_dir = excluded.work_dir, build_dir = excluded.build_dir, binary_path = excluded.binary_path, importcfg_hash = excluded.importcfg_hash, env = excluded.env, command = excluded.command, updated_at = excluded.updated_at, main_package_id = NULL
+RETURNING link_command_id;`,
+		linkCommand.BinaryName, buildTagsID, result.GOOS, result.GOARCH, strings.Join(result.Instrumentation, ","), result.GoVersion, buildFlagsJSON, result.ModMode, linkCommand.BuildMode, argsJSON, result.CgoEnabled, result.WorkDir, result.BuildDir, BinaryPath(linkCommand.BinaryName, result.BuildDir), ImportcfgHash(result.Files[importcfg]), envJSON, sql.NullString{String: linkCommand.Command, Valid: linkCommand.Command != ""})
+	if err := row.Scan(&linkCommandID); err != nil {
+		return 0, "", fmt.Errorf("unable to insert link command: %w", err)
+	}
+
+	if err := deleteLinkCommandRows(ctx, tx, linkCommandRowTables, `= ?`, linkCommandID); err != nil {
+		return 0, "", fmt.Errorf("unable to delete previous link command rows: %w", err)
+	}
+
+	return linkCommandID, importcfg, nil
+}
+
+// deleteLinkCommand deletes the link command stored for the same binary, build
+// tags, platform and instrumentation, and the rows depending on it.
+func deleteLinkCommand(ctx context.Context, tx *sql.Tx, result *BuildResult, linkCommand LinkCommand, buildTagsID int64) error {
+	return deleteLinkCommandRows(ctx, tx, append(linkCommandRowTables, "link_command"), `
+IN (
+	SELECT link_command_id
+	FROM link_command
+	WHERE binary_name = ? AND build_tags_id = ? AND goos = ? AND goarch = ? AND instrumentation = ?
+)`,
+		linkCommand.BinaryName, buildTagsID, result.GOOS, result.GOARCH, strings.Join(result.Instrumentation, ","))
+}
+
+// linkCommandRowTables are the tables holding rows of the link commands,
+// besides link_command.
+var linkCommandRowTables = []string{"link_command_package_file", "importcfg_additional_lines"}
+
+// deleteLinkCommandRows deletes from the tables the rows of the link commands
+// whose ID matches the condition, like `= ?`.
+func deleteLinkCommandRows(ctx context.Context, tx *sql.Tx, tables []string, condition string, args ...any) error {
+	for _, table := range tables {
+		// The table names come from a fixed list
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_command_id `+condition+`;`, args...); err != nil { //nolint:gosec
+			return fmt.Errorf("unable to delete from %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+// parsePackageFileLine returns the package and the file of a `packagefile`
+// line of an importcfg.
+// Import paths can’t contain `=`, contrary to file paths, so the line is split
+// on the first one.
+func parsePackageFileLine(line string) (packageName, file string, err error) {
+	argument, ok := strings.CutPrefix(line, "packagefile ")
+	if !ok {
+		return "", "", fmt.Errorf("invalid importcfg line %q: not a packagefile directive", line)
+	}
+	packageName, file, ok = strings.Cut(argument, "=")
+	switch {
+	case !ok:
+		return "", "", fmt.Errorf("invalid importcfg line %q: missing = between the package and its file", line)
+	case packageName == "":
+		return "", "", fmt.Errorf("invalid importcfg line %q: empty package", line)
+	case file == "":
+		return "", "", fmt.Errorf("invalid importcfg line %q: empty file", line)
+	case !filepath.IsAbs(file) && !strings.HasPrefix(file, goCachePrefix):
+		return "", "", fmt.Errorf("invalid importcfg line %q: file isn’t an absolute path", line)
+	}
+
+	return packageName, file, nil
+}
+
+// insertPackageFiles inserts the `packagefile` lines of an importcfg by
+// batches and associates them to the link command.
+func insertPackageFiles(ctx context.Context, tx *sql.Tx, stmts *statements, linkCommandID int64, lines []string) error {
+	for batch := range slices.Chunk(lines, packageFilesBatchSize) {
+		packageFiles := make([]any, 0, 2*len(batch))
+		files := make([]any, 1, 1+len(batch))
+		files[0] = linkCommandID
+		for _, line := range batch {
+			packageName, file, err := parsePackageFileLine(line)
+			if err != nil {
+				return err
+			}
+			packageFiles = append(packageFiles, packageName, file)
+			files = append(files, file)
+		}
+
+		var err error
+		if len(batch) == packageFilesBatchSize {
+			_, err = stmts.insertPackageFiles.ExecContext(ctx, packageFiles...)
+		} else {
+			insertPackageFiles, _ := packageFilesQueries(len(batch))
+			_, err = tx.ExecContext(ctx, insertPackageFiles, packageFiles...)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to insert package files: %w", err)
+		}
+
+		if len(batch) == packageFilesBatchSize {
+			_, err = stmts.insertLinkCommandPackageFiles.ExecContext(ctx, files...)
+		} else {
+			_, insertLinkCommandPackageFiles := packageFilesQueries(len(batch))
+			_, err = tx.ExecContext(ctx, insertLinkCommandPackageFiles, files...)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to insert link command package files: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// updateLinkCommand sets the main package of the link command, the argument at
+// position mainPos, and replaces it with the `MAIN PACKAGE` placeholder.
+func updateLinkCommand(ctx context.Context, tx *sql.Tx, linkCommandID int64, mainPos int, mainFile string) error {
+	var mainPackageID sql.NullInt64
+	row := tx.QueryRowContext(ctx, `
+UPDATE link_command
+SET main_package_id = (
+	SELECT package_file_id
+	FROM package_file
+	WHERE file = ?
+), args = jsonb_replace(args, ?, 'MAIN PACKAGE')
+WHERE link_command_id = ?
+RETURNING main_package_id;
+`, mainFile, fmt.Sprintf("$[%d]", mainPos), linkCommandID)
+	if err := row.Scan(&mainPackageID); err != nil {
+		return fmt.Errorf("unable to update link command: %w", err)
+	}
+	if !mainPackageID.Valid {
+		return fmt.Errorf("main package %s not found in database", mainFile)
+	}
+
+	return nil
+}
+
+// mainPackageArg returns the position of the main package among the arguments
+// of a link command, the last one, and its file among the package files of the
+// importcfg. Stray quotes and unclean paths left by unusual quoting of the link
+// command are ignored.
+// The main package can be given by its import path instead, when it isn’t the
+// last argument.
+func mainPackageArg(args []string, packageFiles []string, mainPackage string) (int, string, error) {
+	if len(args) == 0 {
+		return 0, "", errors.New("link command without arguments")
+	}
+	if mainPackage != "" {
+		return namedMainPackageArg(args, packageFiles, mainPackage)
+	}
+	mainPos := len(args) - 1
+	mainArg := filepath.Clean(strings.Trim(args[mainPos], `'"`))
+
+	for _, line := range packageFiles {
+		_, file, _ := strings.Cut(line, "=")
+		if file == args[mainPos] || filepath.Clean(file) == mainArg {
+			return mainPos, file, nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("main package %s of the link command isn’t among the package files of its importcfg", args[mainPos])
+}
+
+// namedMainPackageArg returns the position of the last argument of a link
+// command which is the file of the package mainPackage, and this file.
+func namedMainPackageArg(args []string, packageFiles []string, mainPackage string) (int, string, error) {
+	for _, line := range packageFiles {
+		packageName, file, _ := strings.Cut(strings.TrimPrefix(line, "packagefile "), "=")
+		if packageName != mainPackage {
+			continue
+		}
+		for i := len(args) - 1; i >= 0; i-- {
+			if args[i] == file || filepath.Clean(strings.Trim(args[i], `'"`)) == filepath.Clean(file) {
+				return i, file, nil
+			}
+		}
+		return 0, "", fmt.Errorf("file %s of main package %s isn’t an argument of the link command", file, mainPackage)
+	}
+
+	return 0, "", fmt.Errorf("main package %s isn’t among the package files of the importcfg", mainPackage)
+}
+
+func insertAdditionalLines(ctx context.Context, stmts *statements, linkCommandID int64, pos int, line string) error {
+	hash := lineHash(line)
+	if _, err := stmts.insertImportcfgLine.ExecContext(ctx, hash, line); err != nil {
+		return fmt.Errorf("unable to insert importcfg line: %w", err)
+	}
+	if _, err := stmts.insertAdditionalLine.ExecContext(ctx, linkCommandID, pos, hash); err != nil {
+		return fmt.Errorf("unable to insert additional lines: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,427 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package interceptor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helloResult returns the build result of a binary linking a main package with
+// fmt, both in the Go build cache.
+func helloResult(binaryName string, buildTags []string) *BuildResult {
+	return &BuildResult{
+		LinkCommands: []LinkCommand{{
+			Args:       []string{"-o", "/tmp/go-build1/b001/exe/a.out", "-importcfg", "/tmp/go-build1/b001/importcfg.link", "-buildmode=exe", "/home/gopher/.cache/go-build/aa/aa-d"},
+			BinaryName: binaryName,
+			BuildMode:  "exe",
+		}},
+		Files: map[string][]string{
+			"/tmp/go-build1/b001/importcfg.link": {
+				"packagefile main=/home/gopher/.cache/go-build/aa/aa-d",
+				"packagefile fmt=/home/gopher/.cache/go-build/bb/bb-d",
+				`modinfo "hello"`,
+			},
+		},
+		BuildTags: buildTags,
+		GOOS:      "linux",
+		GOARCH:    "amd64",
+		GoVersion: "go1.27.1",
+	}
+}
+
+// openMemoryDB opens an empty database in memory, closed at the end of the
+// test.
+func openMemoryDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	db, err := OpenDB(context.Background(), MemoryDB, time.Second)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Error(err)
+		}
+	})
+
+	return db
+}
+
+func TestStore(t *testing.T) {
+	helloArgs := []string{"-o", "PLACEHOLDER", "-importcfg", "PLACEHOLDER", "-buildmode=exe", "MAIN PACKAGE"}
+	duplicateOutput := helloResult("hello", nil)
+	duplicateOutput.LinkCommands[0].Args = slices.Insert(duplicateOutput.LinkCommands[0].Args, 0, "-o", "/tmp/go-build1/b001/exe/b.out")
+	unknownMainPackage := helloResult("hello", nil)
+	unknownMainPackage.LinkCommands[0].Args[5] = "/home/gopher/.cache/go-build/cc/cc-d"
+
+	type linkCommand struct {
+		binaryName string
+		buildTags  string
+		args       []string
+	}
+	tests := []struct {
+		name             string
+		results          []*BuildResult // Stored in order
+		wantPartial      bool
+		want             []linkCommand
+		wantPackageFiles int
+	}{
+		{
+			name:             "new link command",
+			results:          []*BuildResult{helloResult("hello", nil)},
+			want:             []linkCommand{{"hello", "", helloArgs}},
+			wantPackageFiles: 2,
+		},
+		{
+			name:             "stored again",
+			results:          []*BuildResult{helloResult("hello", nil), helloResult("hello", nil)},
+			want:             []linkCommand{{"hello", "", helloArgs}},
+			wantPackageFiles: 2,
+		},
+		{
+			name:             "equivalent build tags",
+			results:          []*BuildResult{helloResult("hello", []string{"b", "a"}), helloResult("hello", []string{"a", "b", "a"})},
+			want:             []linkCommand{{"hello", "a,b", helloArgs}},
+			wantPackageFiles: 2,
+		},
+		{
+			name:             "other build tags",
+			results:          []*BuildResult{helloResult("hello", nil), helloResult("hello", []string{"a"})},
+			want:             []linkCommand{{"hello", "", helloArgs}, {"hello", "a", helloArgs}},
+			wantPackageFiles: 2,
+		},
+		{
+			name:             "package files shared by several binaries",
+			results:          []*BuildResult{helloResult("hello", nil), helloResult("bye", nil)},
+			want:             []linkCommand{{"hello", "", helloArgs}, {"bye", "", helloArgs}},
+			wantPackageFiles: 2,
+		},
+		{
+			name:        "duplicate output flag",
+			results:     []*BuildResult{duplicateOutput},
+			wantPartial: true,
+		},
+		{
+			name:        "main package not in importcfg",
+			results:     []*BuildResult{unknownMainPackage},
+			wantPartial: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openMemoryDB(t)
+
+			for _, result := range tt.results {
+				err := Store(ctx, db, result, false)
+				var partialErr *PartialStoreError
+				if tt.wantPartial != errors.As(err, &partialErr) || (err != nil && partialErr == nil) {
+					t.Fatalf("Store() error = %v, want partial store error %t", err, tt.wantPartial)
+				}
+			}
+
+			rows, err := db.QueryContext(ctx, `
+SELECT binary_name, canonical, json(args)
+FROM link_command
+JOIN build_tags USING (build_tags_id)
+ORDER BY link_command_id;`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []linkCommand
+			for rows.Next() {
+				var c linkCommand
+				var argsJSON string
+				if err := rows.Scan(&c.binaryName, &c.buildTags, &argsJSON); err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal([]byte(argsJSON), &c.args); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, c)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b linkCommand) bool {
+				return a.binaryName == b.binaryName && a.buildTags == b.buildTags && slices.Equal(a.args, b.args)
+			}) {
+				t.Errorf("stored link commands = %q, want %q", got, tt.want)
+			}
+
+			var packageFiles int
+			if err := db.QueryRowContext(ctx, `SELECT count(*) FROM package_file;`).Scan(&packageFiles); err != nil {
+				t.Fatal(err)
+			}
+			if packageFiles != tt.wantPackageFiles {
+				t.Errorf("stored %d package files, want %d", packageFiles, tt.wantPackageFiles)
+			}
+		})
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	tests := []struct {
+		name        string
+		reopen      bool // Whether the database was already opened by OpenDB
+		userVersion int  // Schema version of the existing database, none if 0
+		wantErr     string
+	}{
+		{
+			name: "new database",
+		},
+		{
+			name:   "up to date",
+			reopen: true,
+		},
+		{
+			name:        "newer version",
+			userVersion: SchemaVersion() + 1,
+			wantErr:     "is newer than the latest supported version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dbPath := filepath.Join(t.TempDir(), "links.db")
+			switch {
+			case tt.reopen:
+				db, err := OpenDB(ctx, dbPath, time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Close(); err != nil {
+					t.Fatal(err)
+				}
+			case tt.userVersion > 0:
+				db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=rwc")
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = db.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d;`, tt.userVersion))
+				if err := errors.Join(err, db.Close()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db, err := OpenDB(ctx, dbPath, time.Second)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OpenDB() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			var version int
+			if err := db.QueryRowContext(ctx, `PRAGMA user_version;`).Scan(&version); err != nil {
+				t.Fatal(err)
+			}
+			if version != SchemaVersion() {
+				t.Errorf("schema version = %d, want %d", version, SchemaVersion())
+			}
+		})
+	}
+}
+
+// largeResult returns the build result of a binary linking packages package
+// files, like large binaries linking more than a thousand packages.
+func largeResult(packages int) *BuildResult {
+	result := helloResult("large", nil)
+	importcfg := result.Files["/tmp/go-build1/b001/importcfg.link"]
+	for i := range packages - 2 {
+		importcfg = slices.Insert(importcfg, 1, fmt.Sprintf("packagefile example.com/pkg%d=/home/gopher/.cache/go-build/%02x/%064x-d", i, i%256, i))
+	}
+	result.Files["/tmp/go-build1/b001/importcfg.link"] = importcfg
+
+	return result
+}
+
+func BenchmarkStore(b *testing.B) {
+	ctx := context.Background()
+	result := largeResult(1500)
+
+	for range b.N {
+		b.StopTimer()
+		db, err := OpenDB(ctx, MemoryDB, time.Second)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		if err := Store(ctx, db, result, false); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		if err := db.Close(); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkStoreLinkCommandArgs(b *testing.B) {
+	ctx := context.Background()
+
+	for _, n := range []int{20, 500} {
+		b.Run(fmt.Sprintf("%d args", n), func(b *testing.B) {
+			result := largeResult(300)
+			args := result.LinkCommands[0].Args
+			for i := len(args); i < n; i++ {
+				args = slices.Insert(args, len(args)-1, fmt.Sprintf("-X=main.value%d=%d", i, i))
+			}
+			result.LinkCommands[0].Args = args
+
+			db := openMemoryDB(b)
+			b.ResetTimer()
+			for range b.N {
+				if err := Store(ctx, db, result, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreForce(t *testing.T) {
+	tests := []struct {
+		name       string
+		force      bool
+		wantNewRow bool // Whether the link command gets a new ID and creation time
+	}{
+		{
+			name: "updated",
+		},
+		{
+			name:       "forced",
+			force:      true,
+			wantNewRow: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openMemoryDB(t)
+
+			type row struct {
+				id                   int64
+				createdAt, updatedAt string
+			}
+			stored := func() row {
+				t.Helper()
+				var r row
+				if err := db.QueryRowContext(ctx, `SELECT link_command_id, created_at, updated_at FROM link_command WHERE binary_name = 'hello';`).Scan(&r.id, &r.createdAt, &r.updatedAt); err != nil {
+					t.Fatal(err)
+				}
+				return r
+			}
+
+			// Another link command is stored in between so that a new row
+			// wouldn’t reuse the ID of the deleted one
+			if err := Store(ctx, db, helloResult("hello", nil), false); err != nil {
+				t.Fatal(err)
+			}
+			if err := Store(ctx, db, helloResult("bye", nil), false); err != nil {
+				t.Fatal(err)
+			}
+			before := stored()
+			// The times have a millisecond resolution
+			time.Sleep(10 * time.Millisecond)
+			if err := Store(ctx, db, helloResult("hello", nil), tt.force); err != nil {
+				t.Fatal(err)
+			}
+			after := stored()
+
+			if gotNewRow := after.id != before.id; gotNewRow != tt.wantNewRow {
+				t.Errorf("link command ID %d -> %d, want a new ID %t", before.id, after.id, tt.wantNewRow)
+			}
+			if gotNewRow := after.createdAt != before.createdAt; gotNewRow != tt.wantNewRow {
+				t.Errorf("creation time %s -> %s, want a new creation time %t", before.createdAt, after.createdAt, tt.wantNewRow)
+			}
+			if after.updatedAt == before.updatedAt {
+				t.Errorf("update time %s unchanged", after.updatedAt)
+			}
+
+			var packageFiles int
+			if err := db.QueryRowContext(ctx, `SELECT count(*) FROM link_command_package_file WHERE link_command_id = ?;`, after.id).Scan(&packageFiles); err != nil {
+				t.Fatal(err)
+			}
+			if packageFiles != 2 {
+				t.Errorf("link command has %d package files, want 2", packageFiles)
+			}
+		})
+	}
+}
+
+func TestMigrateBinaryPath(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "links.db")
+
+	// Link commands stored before version 12 only have a binary name
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations[:11] {
+		for _, sqlStmt := range migration {
+			if _, err := db.ExecContext(ctx, sqlStmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO build_tags (build_tags_id, tags) VALUES (1, jsonb('[]'));`); err != nil {
+		t.Fatal(err)
+	}
+	binaryPaths := map[string]string{
+		"hello":                     "",
+		"bin/hello":                 "",
+		"/home/gopher/hello":        "/home/gopher/hello",
+		`C:\Users\gopher\hello.exe`: `C:\Users\gopher\hello.exe`,
+		"C:/Users/gopher/hello.exe": "C:/Users/gopher/hello.exe",
+		`\\server\share\hello.exe`:  `\\server\share\hello.exe`,
+	}
+	for binaryName := range binaryPaths {
+		if _, err := db.ExecContext(ctx, `INSERT INTO link_command (binary_name, build_tags_id, goos, goarch) VALUES (?, 1, 'linux', 'amd64');`, binaryName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.ExecContext(ctx, `PRAGMA user_version = 11;`)
+	if err := errors.Join(err, db.Close()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDB(ctx, dbPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for binaryName, want := range binaryPaths {
+		var got sql.NullString
+		if err := db.QueryRowContext(ctx, `SELECT binary_path FROM link_command WHERE binary_name = ?;`, binaryName).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String != want {
+			t.Errorf("binary_path of %q = %q, want %q", binaryName, got.String, want)
+		}
+	}
+}
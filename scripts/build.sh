@@ -4,6 +4,6 @@ set -euo pipefail
 ROOT_DIR="$(git rev-parse --show-toplevel)"
 cd "$ROOT_DIR"
 
-for cmd in interceptor executor; do
+for cmd in interceptor executor golinkinterceptor; do
 	go build -v -o "$ROOT_DIR/bin/$cmd" ./cmd/$cmd
 done
@@ -4,14 +4,1157 @@ set -euo pipefail
 ROOT_DIR="$(git rev-parse --show-toplevel)"
 LOG_LEVEL=${LOG_LEVEL:-0}
 
-cd "$ROOT_DIR/test"
 dbpath=$(mktemp --tmpdir golinkinterceptor.db.XXXXXXXXXX)
-trap 'rm -f "$dbpath"' EXIT
+outdir=$(mktemp -d --tmpdir golinkinterceptor.out.XXXXXXXXXX)
+trap 'rm -rf "$dbpath" "$dbpath-wal" "$dbpath-shm" "$outdir"' EXIT
+
+# The test program is built from a copy, so that the binaries are written to
+# the output directory rather than to the source tree
+srcdir="$outdir/src"
+cp -R "$ROOT_DIR/test" "$srcdir"
+cd "$srcdir"
+
+# expect <expected output> <command> [args...]
+expect() {
+	local want="$1"
+	shift
+	local got
+	got=$("$@")
+	if [[ "$got" != "$want" ]]; then
+		echo "FAIL: $*" >&2
+		echo "  got:  $got" >&2
+		echo "  want: $want" >&2
+		exit 1
+	fi
+}
+
+# expect_status <expected exit status> <command> [args...]
+expect_status() {
+	local want="$1"
+	shift
+	local got=0
+	"$@" >/dev/null 2>&1 || got=$?
+	if [[ "$got" != "$want" ]]; then
+		echo "FAIL: $* exited with status $got instead of $want" >&2
+		exit 1
+	fi
+}
+
+# expect_failure <command> [args...]
+expect_failure() {
+	if "$@" >/dev/null 2>&1; then
+		echo "FAIL: $* should have failed" >&2
+		exit 1
+	fi
+}
 
 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo .
 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build --tags A -o foo .
-"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build --tags B -o foo .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build --tags=B -o=foo .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -ldflags "-X 'main.version=v1 \"beta\"'" -o foo-ldflags .
+
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags A -- foo
+expect "Hello B!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags B -- foo
+expect $'Hello unknown!\nVersion "v1 \\"beta\\""' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-ldflags
+
+# Relink with a replaced package object file
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --replace "fmt=$(go list -export -f '{{.Export}}' fmt)" -- foo
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --replace "not/a/package=$(go list -export -f '{{.Export}}' fmt)" -- foo
+
+# Relink with additional -X definitions
+expect $'Hello unknown!\nVersion "v2"' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -X main.version=v1 -X main.version=v2 -- foo-ldflags
+
+# The relinked binary is identical to a fresh build
+expect "Relinked binary is identical to a fresh build of ." "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --verify . -- foo
+expect "Relinked binary is identical to a fresh build of ." "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags A --verify . -- foo
+expect "Relinked binary is identical to a fresh build of ." "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --verify . -- foo-ldflags
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -X main.version=v2 --verify . -- foo-ldflags
+
+# The build ID is recomputed like `go build` does, or left out with -no-buildid
+go build -o "$outdir/fresh-buildid" .
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -o "$outdir/relinked-buildid" -- foo
+expect "$(go tool buildid "$outdir/fresh-buildid")" go tool buildid "$outdir/relinked-buildid"
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --no-buildid -o "$outdir/no-buildid" -- foo
+expect "" go tool buildid "$outdir/no-buildid"
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --no-buildid --dry-run -- foo)
+if [[ "$output" == *"-buildid"* ]]; then
+	echo "FAIL: build ID left in the link command: $output" >&2
+	exit 1
+fi
+
+# Dry run prints the link command without linking
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo)
+if [[ "$output" != *"Importcfg "*"packagefile fmt="* ]]; then
+	echo "FAIL: unexpected dry run output: $output" >&2
+	exit 1
+fi
+
+# The dumped importcfg matches the one of a fresh build
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dump-importcfg "$outdir/importcfg.link" -- foo
+go build -x -o "$outdir/fresh" . 2>&1 | sed -n '/^cat >.*\/importcfg\.link << /,/^EOF$/{//!p}' >"$outdir/importcfg.fresh"
+grep -q '^packagefile fmt=' "$outdir/importcfg.fresh"
+expect "" diff <(sort "$outdir/importcfg.fresh") <(sort "$outdir/importcfg.link")
+# The lines other than package files keep their order
+expect "" diff <(grep -v '^packagefile ' "$outdir/importcfg.fresh") <(grep -v '^packagefile ' "$outdir/importcfg.link")
+# The importcfg is byte-stable, with its package files sorted first
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dump-importcfg "$outdir/importcfg.again" -- foo
+expect "" cmp "$outdir/importcfg.link" "$outdir/importcfg.again"
+expect "" diff <(sed -n 's/^packagefile \([^=]*\)=.*/\1/p' "$outdir/importcfg.link" | LC_ALL=C sort) <(sed -n 's/^packagefile \([^=]*\)=.*/\1/p' "$outdir/importcfg.link")
+expect "" diff <(sed -n '/^packagefile /!{=;q}' "$outdir/importcfg.link") <(grep -c '^packagefile ' "$outdir/importcfg.link" | awk '{print $1 + 1}')
+
+# The prefixes of package file paths can be rewritten, like for a Go build
+# cache mounted elsewhere, other paths and partial path elements are left intact
+fmt_dir=$(sed -n 's/^packagefile fmt=\(.*\)\/[^/]*$/\1/p' "$outdir/importcfg.link")
+ln -s "$fmt_dir" "$outdir/mounted"
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --path-map "${fmt_dir%?}=/nonexistent" --path-map "$fmt_dir=$outdir/mounted" --dump-importcfg "$outdir/importcfg.mapped" -- foo
+grep -q "^packagefile fmt=$outdir/mounted/" "$outdir/importcfg.mapped"
+expect "" diff <(sed "s|=$fmt_dir/|=$outdir/mounted/|" "$outdir/importcfg.link") "$outdir/importcfg.mapped"
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --path-map "$fmt_dir=$outdir/mounted" -- foo
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --path-map "$fmt_dir" --dump-importcfg "$outdir/importcfg.mapped" -- foo
+
+# The captured link commands can be printed without storing them
+output=$("$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/print.db" --print --no-write -- go build -ldflags "-X 'main.version=v1 \"beta\"'" -o foo-print .)
+expected=$'\t"-X"\n\t"main.version=v1 \\"beta\\""\n'
+if [[ "$output" != $'Binary: foo-print\nCommand: '*$'/link -o '*$'\nArguments:\n\t"-o"\n'*"$expected"*$'\n\t"'"$(go env GOCACHE)/"*'"' ]]; then
+	echo "FAIL: unexpected printed link command: $output" >&2
+	exit 1
+fi
+[[ ! -e "$outdir/print.db" ]] || { echo "FAIL: link command written with -no-write" >&2; exit 1; }
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/print.db" --print -- go build -o foo-print . >/dev/null
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/print.db" -- foo-print
+
+# The build output can be read from a file instead of running the build
+expect_failure "$ROOT_DIR/bin/interceptor" --db "$outdir/from-file.db" --from-file testdata/build-x.log
+# The package files are in the Go build cache given by -gocache
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/from-file.db" --gocache /home/gopher/.cache/go-build --from-file testdata/build-x.log --no-write
+# Exactly the package files outside of it are reported, sorted and once each
+sed 's#=/home/gopher/.cache/go-build/\(f[0-9a-f]\)/#=/elsewhere/\1/#' testdata/build-x.log >"$outdir/build-x-uncached.log"
+output=$("$ROOT_DIR/bin/interceptor" --db "$outdir/from-file.db" --gocache /home/gopher/.cache/go-build --from-file "$outdir/build-x-uncached.log" --no-write 2>&1 || true)
+expect "$(grep -o '/elsewhere/.*' "$outdir/build-x-uncached.log" | sort -u)" sed -n 's/^\t//p' <<<"$output"
+output=$("$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/from-file.db" --from-file testdata/build-x.log --skip-cache-check --print)
+if [[ "$output" != $'Binary: hello\nCommand: '"$(grep '/link ' testdata/build-x.log)"$'\nArguments:\n\t"-o"\n\t"/tmp/go-build1234567890/b001/exe/a.out"\n'*$'\t"/home/gopher/.cache/go-build/fc/fc281eec3b30bc47f7f060bc21527a1cc85b83f5664265656c009eba048c9fe2-d"' ]]; then
+	echo "FAIL: unexpected link command read from a file: $output" >&2
+	exit 1
+fi
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/from-file.db" --verify-files=false --dump-importcfg "$outdir/importcfg.from-file" -- hello
+# The line running the linker is stored as it was printed
+expect "\"$(grep '/link ' testdata/build-x.log)\"," sed -n 's/^ *"command": //p' <("$ROOT_DIR/bin/interceptor" --db "$outdir/from-file.db" --export /dev/stdout)
+expect "" diff <(sed -n '/^cat >.*\/importcfg\.link << /,/^EOF$/{//!p}' testdata/build-x.log | sort) <(sort "$outdir/importcfg.from-file")
+# A linker outside of GOTOOLDIR is still found, with a warning
+sed 's#/opt/go/pkg/tool/linux_amd64/link #/nix/store/go-toolchain/libexec/link #' testdata/build-x.log >"$outdir/build-x-elsewhere.log"
+expect "${output/\/opt\/go\/pkg\/tool\/linux_amd64\/link /\/nix\/store\/go-toolchain\/libexec\/link }" "$ROOT_DIR/bin/interceptor" --db "$outdir/elsewhere.db" --from-file "$outdir/build-x-elsewhere.log" --skip-cache-check --print --no-write
+warnings=$("$ROOT_DIR/bin/interceptor" --log-level 1 --db "$outdir/elsewhere.db" --from-file "$outdir/build-x-elsewhere.log" --skip-cache-check --no-write 2>&1)
+if [[ "$warnings" != *"level=WARN msg=\"Linker found outside of GOTOOLDIR\""*"/nix/store/go-toolchain/libexec/link "* ]]; then
+	echo "FAIL: missing warning about the linker location: $warnings" >&2
+	exit 1
+fi
+# A link command with several -o or -importcfg flags isn’t stored, as their
+# values would all be substituted by the executor
+sed 's#/link -o \([^ ]*\) #/link -o \1 -o /tmp/duplicate #' testdata/build-x.log >"$outdir/build-x-duplicate.log"
+failure=$("$ROOT_DIR/bin/interceptor" --db "$outdir/duplicate.db" --from-file "$outdir/build-x-duplicate.log" --skip-cache-check -- go build -o hello . 2>&1 || true)
+if [[ "$failure" != *"link command has 2 -o flags instead of one"* ]]; then
+	echo "FAIL: duplicate -o flag not reported: $failure" >&2
+	exit 1
+fi
+expect_failure "$ROOT_DIR/bin/executor" --db "$outdir/duplicate.db" --verify-files=false --dump-importcfg "$outdir/importcfg.duplicate" -- hello
+# CRLF line endings are stripped from the captured lines
+sed 's/$/\r/' testdata/build-x.log >"$outdir/build-x-crlf.log"
+expect "$output" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/crlf.db" --from-file "$outdir/build-x-crlf.log" --skip-cache-check --print
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/crlf.db" --verify-files=false --dump-importcfg "$outdir/importcfg.crlf" -- hello
+expect "" diff "$outdir/importcfg.from-file" "$outdir/importcfg.crlf"
+go build -o foo-stdin .
+rm foo-stdin
+go build -x -o foo-stdin . 2>&1 | "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/from-file.db" --from-file - -- go build -o foo-stdin .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/from-file.db" -- foo-stdin
+
+# The main package can be named when it isn’t the last argument of the linker
+sed '/\/link /s#\(-d\)$#\1 -v#' testdata/build-x.log >"$outdir/build-x-main.log"
+expect_failure "$ROOT_DIR/bin/interceptor" --db "$outdir/main.db" --from-file "$outdir/build-x-main.log" --skip-cache-check
+expect_failure "$ROOT_DIR/bin/interceptor" --db "$outdir/main.db" --from-file "$outdir/build-x-main.log" --skip-cache-check --main-package not/a/package
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/main.db" --from-file "$outdir/build-x-main.log" --skip-cache-check --main-package github.com/L3n41c/golinkinterceptor/tests
+output=$("$ROOT_DIR/bin/interceptor" --db "$outdir/main.db" --export /dev/stdout)
+if [[ "$output" != *$'"MAIN PACKAGE",\n        "-v"\n'*'"package": "github.com/L3n41c/golinkinterceptor/tests"'* ]]; then
+	echo "FAIL: unexpected main package of the link command: $output" >&2
+	exit 1
+fi
+
+# Test binaries are captured from `go test -c` and run with the test flags
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" -- go test -c -o "$outdir/greet.test" ./greet
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" -- "$outdir/greet.test" -test.run Greeting -test.v)
+if [[ "$output" != *"--- PASS: TestGreeting"* ]]; then
+	echo "FAIL: test binary not replayed: $output" >&2
+	exit 1
+fi
+expect_status 1 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" -- "$outdir/greet.test" -test.run Failing
+# The `go test -c -x` output can be read from a file as well
+go test -c -x -o "$outdir/greet-from-file.test" ./greet >"$outdir/go-test.log" 2>&1
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" --from-file "$outdir/go-test.log" -- go test -c -o "$outdir/greet-from-file.test" ./greet
+expect_status 0 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" -- "$outdir/greet-from-file.test" -test.run Greeting
+# `go test` doesn’t link anything without -c
+expect_status 2 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/test.db" -- go test -o "$outdir/greet.test" ./greet
+
+# The Go API relinks a binary in-process from an in-memory database
+go -C "$ROOT_DIR" build -o "$outdir/replay" ./examples/replay
+go build -x -o "$outdir/replay-foo" . 2>&1 | "$outdir/replay" -o "$outdir/replayed" "$outdir/replay-foo" 2>/dev/null
+expect "Hello unknown!" "$outdir/replayed"
+rm "$outdir/replay-foo"
+expect "Hello unknown!" sh -c 'go build -x -o "$1" . 2>&1 | "$2" -exec "$1" 2>/dev/null' sh "$outdir/replay-foo" "$outdir/replay"
+rm "$outdir/replay-foo"
+expect_failure sh -c 'go build -x -o "$1" . 2>&1 | "$2" -o "$3" not-intercepted' sh "$outdir/replay-foo" "$outdir/replay" "$outdir/not-replayed"
+
+# Building a library is reported as it doesn’t link anything
+output=$("$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/strings.a" strings 2>&1 || true)
+if [[ "$output" != *"only main packages are linked"* ]]; then
+	echo "FAIL: unexpected output when building a library: $output" >&2
+	exit 1
+fi
+
+# Package files never reaching the Go build cache are reported
+mkdir "$outdir/uncached"
+cat >"$outdir/uncached/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	echo build >>"$outdir/uncached/builds"
+	"$(command -v go)" "\$@" 2>&1 | sed "s|$(go env GOCACHE)|/not/the/cache|"
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/uncached/go"
+output=$(PATH="$outdir/uncached:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-uncached . 2>&1 || true)
+if [[ "$output" != *"not in the Go build cache after 3 builds"*"/not/the/cache/"* ]]; then
+	echo "FAIL: unexpected output when package files aren’t cached: $output" >&2
+	exit 1
+fi
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-uncached
+expect 3 wc -l <"$outdir/uncached/builds"
+rm "$outdir/uncached/builds"
+output=$(PATH="$outdir/uncached:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --build-attempts 5 -- go build -o foo-uncached . 2>&1 || true)
+if [[ "$output" != *"not in the Go build cache after 5 builds"* ]]; then
+	echo "FAIL: unexpected output with 5 build attempts: $output" >&2
+	exit 1
+fi
+expect 5 wc -l <"$outdir/uncached/builds"
+expect_status 2 "$ROOT_DIR/bin/interceptor" --db "$dbpath" --build-attempts 0 -- go build -o foo-uncached .
+
+# Up to date binaries aren’t relinked when they aren’t removed
+rm -f foo-kept
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --remove-output=false -- go build -o foo-kept .
+expect_failure "$ROOT_DIR/bin/interceptor" --db "$dbpath" --remove-output=false -- go build -o foo-kept .
+output=$("$ROOT_DIR/bin/interceptor" --db "$dbpath" --remove-output=false -- go build -o foo-kept . 2>&1 || true)
+if [[ "$output" != *"foo-kept is up to date and wasn’t relinked"* ]]; then
+	echo "FAIL: up to date binary not reported: $output" >&2
+	exit 1
+fi
+
+# The output of `go build -C` is removed from the directory given to -C, so
+# that it's relinked even when up to date
+for _ in 1 2; do
+	(cd "$outdir" && "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -C "$srcdir" -o foo-chdir .)
+done
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-chdir
+
+# A failing `go env` is reported as an error instead of exiting with its status
+mkdir "$outdir/broken-env"
+cat >"$outdir/broken-env/go" <<EOF
+#!/usr/bin/env bash
+if [[ "\$1" == env ]]; then
+	echo "go: broken environment" >&2
+	exit 7
+fi
+exec "$(command -v go)" "\$@"
+EOF
+chmod +x "$outdir/broken-env/go"
+for cmd in "interceptor -- go build -o foo-broken-env ." "executor -- foo"; do
+	status=0
+	output=$(PATH="$outdir/broken-env:$PATH" "$ROOT_DIR/bin/${cmd%% *}" --log-level "$LOG_LEVEL" --db "$dbpath" ${cmd#* } 2>&1) || status=$?
+	if [[ "$status" != 1 || "$output" != *"unable to get Go environment: exit status 7"*"go: broken environment"* ]]; then
+		echo "FAIL: unexpected status $status or output of the $cmd with a failing go env: $output" >&2
+		exit 1
+	fi
+done
+
+# Environment variables referencing variables defined afterwards are expanded
+mkdir "$outdir/nested"
+cat >"$outdir/nested/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	"$(command -v go)" "\$@" 2>&1 | sed 's|^WORK=\(.*\)/\([^/]*\)$|WORK=\$WORK_PARENT/\2\nWORK_PARENT=\1|'
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/nested/go"
+PATH="$outdir/nested:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-nested .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-nested
+
+# The linker of the Go toolchain is used when -link isn’t set
+mkdir -p "$outdir/toolchain/tool" "$outdir/toolchain/bin"
+cat >"$outdir/toolchain/tool/link" <<EOF
+#!/usr/bin/env bash
+touch "$outdir/toolchain/used"
+exec "$(go env GOTOOLDIR)/link" "\$@"
+EOF
+cat >"$outdir/toolchain/bin/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == env ]]; then
+	"$(command -v go)" "\$@" | sed 's|"GOTOOLDIR": ".*"|"GOTOOLDIR": "$outdir/toolchain/tool"|'
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/toolchain/tool/link" "$outdir/toolchain/bin/go"
+expect "Hello unknown!" env PATH="$outdir/toolchain/bin:$PATH" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo
+[[ -e "$outdir/toolchain/used" ]]
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dry-run -- foo)
+if [[ "$output" != "Linker: $(go env GOTOOLDIR)/link"$'\n'* ]]; then
+	echo "FAIL: unexpected linker in dry run output: $output" >&2
+	exit 1
+fi
+
+# A build taking longer than the timeout is interrupted
+mkdir "$outdir/slow"
+cat >"$outdir/slow/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	# Like the go command, stop the subprocesses when interrupted
+	sleep 60 &
+	trap "kill \$!; exit 130" INT
+	wait
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/slow/go"
+start=$SECONDS
+output=$(PATH="$outdir/slow:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --timeout 1s -- go build -o foo-slow . 2>&1 || true)
+if [[ "$output" != *"build interrupted: context deadline exceeded"* ]]; then
+	echo "FAIL: unexpected output when the build times out: $output" >&2
+	exit 1
+fi
+if ((SECONDS - start > 4)); then
+	echo "FAIL: interrupting the build took $((SECONDS - start))s" >&2
+	exit 1
+fi
+
+# The DB path is taken from the -db flag, then from GOLINK_DB
+GOLINK_DB="$outdir/env.db" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" -- go build -o foo-env .
+expect "Hello unknown!" env GOLINK_DB="$outdir/env.db" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" -- foo-env
+expect "Hello unknown!" env GOLINK_DB="$outdir/env.db" "$ROOT_DIR/bin/golinkinterceptor" --log-level "$LOG_LEVEL" exec -- foo-env
+expect "Hello unknown!" env GOLINK_DB="$outdir/env.db" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo
+expect_failure env GOLINK_DB="$outdir/env.db" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-env
+mkdir "$outdir/default"
+(cd "$outdir/default" && env -u GOLINK_DB "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --prune-orphans)
+[[ -e "$outdir/default/link.db" ]]
+
+# The output and importcfg of the link command can be given as -flag=value
+mkdir "$outdir/equals"
+cat >"$outdir/equals/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	"$(command -v go)" "\$@" 2>&1 | sed '/\/link /s| -o \([^ ]*\) -importcfg \([^ ]*\) | -o=\1 -importcfg=\2 |'
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/equals/go"
+PATH="$outdir/equals:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-equals .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-equals
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dry-run -- foo-equals)
+if [[ "$output" != *'"-o='*'"-importcfg='*"packagefile fmt="* ]]; then
+	echo "FAIL: unexpected dry run output with -flag=value arguments: $output" >&2
+	exit 1
+fi
+
+# Package files stored relative to GOCACHE are found in another cache location
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --relative-cache -- go build -o foo-relative .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-relative
+ln -s "$(go env GOCACHE)" "$outdir/cache"
+expect "Hello unknown!" env GOCACHE="$outdir/cache" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-relative
+output=$(GOCACHE="$outdir/cache" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dry-run -- foo-relative)
+if [[ "$output" != *"\"$outdir/cache/"*"packagefile fmt=$outdir/cache/"* ]]; then
+	echo "FAIL: package files not relative to GOCACHE: $output" >&2
+	exit 1
+fi
+# The -gocache flag overrides GOCACHE, relative to the current directory
+output=$(cd "$outdir" && GOCACHE=/nonexistent "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --gocache cache --dry-run -- foo-relative)
+if [[ "$output" != *"packagefile fmt=$outdir/cache/"* ]]; then
+	echo "FAIL: package files not relative to -gocache: $output" >&2
+	exit 1
+fi
+# Absolute paths are kept as is
+output=$(GOCACHE="$outdir/cache" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dry-run -- foo)
+if [[ "$output" != *"packagefile fmt=$(go env GOCACHE)/"* ]]; then
+	echo "FAIL: absolute package files rewritten: $output" >&2
+	exit 1
+fi
+
+# Link commands run by a shell are unwrapped
+mkdir "$outdir/wrapped"
+cat >"$outdir/wrapped/wrap.sed" <<'EOF'
+/\/link /{
+s/'/'\\''/g
+s|.*|/bin/sh -c 'cd $WORK \&\& &'|
+}
+EOF
+cat >"$outdir/wrapped/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	"$(command -v go)" "\$@" 2>&1 | sed -f "$outdir/wrapped/wrap.sed"
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/wrapped/go"
+PATH="$outdir/wrapped:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -ldflags "-X 'main.version=v1 \"beta\"'" -o foo-wrapped .
+expect $'Hello unknown!\nVersion "v1 \\"beta\\""' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-wrapped
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dry-run -- foo-wrapped)
+output=${output%%Importcfg *}
+if [[ "$output" == *"'"* || "$output" == *"&&"* ]]; then
+	echo "FAIL: shell wrapper left in the link command: $output" >&2
+	exit 1
+fi
+
+# The main package is found even if the last argument is oddly quoted
+mkdir "$outdir/quoted"
+cat >"$outdir/quoted/go" <<EOF
+#!/usr/bin/env bash
+set -euo pipefail
+if [[ "\$1" == build ]]; then
+	"$(command -v go)" "\$@" 2>&1 | sed -f "$outdir/quoted/quote.sed"
+else
+	exec "$(command -v go)" "\$@"
+fi
+EOF
+chmod +x "$outdir/quoted/go"
+echo "/\/link /s| \([^ ]*\)\$| '\"\1\"'|" >"$outdir/quoted/quote.sed"
+PATH="$outdir/quoted:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-quoted .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-quoted
+# A main package missing from the importcfg is reported
+echo "/\/link /s| [^ ]*\$| /not/the/main/package.a|" >"$outdir/quoted/quote.sed"
+output=$(PATH="$outdir/quoted:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-quoted . 2>&1 || true)
+if [[ "$output" != *"main package /not/the/main/package.a of the link command isn’t among the package files"* ]]; then
+	echo "FAIL: unexpected output when the main package isn’t found: $output" >&2
+	exit 1
+fi
+
+# The link commands of a build are stored even if one of them can’t be
+echo '/^packagefile [^=]*\/cmd\/bye=/d' >"$outdir/quoted/quote.sed"
+mkdir "$outdir/partial"
+output=$(PATH="$outdir/quoted:$PATH" "$ROOT_DIR/bin/interceptor" --log-level 1 --db "$outdir/partial.db" -- go build -o "$outdir/partial/" . ./cmd/bye 2>&1 || true)
+if [[ "$output" != *'level=WARN msg="Unable to store link command" binary='"$outdir/partial/bye"* || "$output" != *"unable to store the link commands of $outdir/partial/bye (1 of 2 stored)"* ]]; then
+	echo "FAIL: unexpected output when a link command can’t be stored: $output" >&2
+	exit 1
+fi
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/partial.db" -- "$outdir/partial/tests"
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/partial.db" -- "$outdir/partial/bye"
+
+# Malformed package file lines of the importcfg are reported
+for malformed in 's|^packagefile fmt=.*|packagefile fmt=|:empty file' \
+	's|^packagefile fmt=|packagefile =|:empty package' \
+	's|^packagefile fmt=|packagefile fmt |:missing = between the package and its file' \
+	's|^packagefile fmt=/|packagefile fmt=relative/|:file isn’t an absolute path'; do
+	echo "${malformed%%:*}" >"$outdir/quoted/quote.sed"
+	output=$(PATH="$outdir/quoted:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-quoted . 2>&1 || true)
+	if [[ "$output" != *'invalid importcfg line "packagefile '*"${malformed#*:}"* ]]; then
+		echo "FAIL: unexpected output for a malformed package file line (${malformed%%:*}): $output" >&2
+		exit 1
+	fi
+done
+
+# Lines of the build output longer than the 64 KiB default of bufio.Scanner
+# are parsed, up to -max-line-length
+long_version=$(printf 'v%.0s' {1..70000})
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -ldflags "-X main.version=$long_version" -o foo-long .
+expect $'Hello unknown!\nVersion "'"$long_version"'"' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-long
+# The importcfg keeps its modinfo line longer than 64 KiB along with the
+# package files
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --dump-importcfg "$outdir/importcfg.long" -- foo-long
+go build -x -ldflags "-X main.version=$long_version" -o "$outdir/fresh-long" . 2>&1 | sed -n '/^cat >.*\/importcfg\.link << /,/^EOF$/{//!p}' >"$outdir/importcfg.long.fresh"
+[[ $(grep '^modinfo ' "$outdir/importcfg.long" | wc -c) -gt 65536 ]]
+expect "" diff <(sort "$outdir/importcfg.long.fresh") <(sort "$outdir/importcfg.long")
+output=$("$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --max-line-length 65536 -- go build -ldflags "-X main.version=$long_version" -o foo-long . 2>&1 || true)
+if [[ "$output" != *"line longer than 65536 bytes in the build output"* ]]; then
+	echo "FAIL: unexpected output for a line longer than -max-line-length: $output" >&2
+	exit 1
+fi
+
+# Interceptions merge into the database, replacing the link command of a
+# binary intercepted again and keeping the others
+mergedb="$outdir/merge.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$mergedb" -- go build -ldflags "-X main.version=v1" -o foo-merge .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$mergedb" -- go build -o "$outdir/merge-bye" ./cmd/bye
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$mergedb" -- go build -ldflags "-X main.version=v2" -o foo-merge .
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$mergedb" --list)
+if [[ $(wc -l <<<"$output") != 3 || $(grep -c '^foo-merge ' <<<"$output") != 1 || "$output" != *'"-ldflags=-X main.version=v2"'* || "$output" == *"v1"* ]]; then
+	echo "FAIL: unexpected link commands after merging interceptions: $output" >&2
+	exit 1
+fi
+expect $'Hello unknown!\nVersion "v2"' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$mergedb" -- foo-merge
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$mergedb" -- "$outdir/merge-bye"
+expect "" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$mergedb" --validate
+expect 1 grep -c '"binary_name": "foo-merge"' <("$ROOT_DIR/bin/interceptor" --db "$mergedb" --export /dev/stdout)
+
+# With -force, the link command stored for a binary is deleted and inserted
+# again
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -ldflags "-X main.version=v5" -o foo-force .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --force -- go build -ldflags "-X main.version=v6" -o foo-force .
+expect $'Hello unknown!\nVersion "v6"' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-force
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --list)
+if [[ $(grep -c '^foo-force ' <<<"$output") != 1 || "$output" != *'"-ldflags=-X main.version=v6"'* || "$output" == *"v5"* ]]; then
+	echo "FAIL: unexpected link commands after -force: $output" >&2
+	exit 1
+fi
+
+# The objects of cgo packages are linked from their package files
+CGO_ENABLED=1 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/cgo" ./cmd/cgo
+expect "Cgo says 3" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- "$outdir/cgo"
+CGO_ENABLED=0 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-nocgo .
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --list --json)
+if [[ "$output" != *"\"binary_name\": \"$outdir/cgo\","*'"cgo_enabled": "1",'* ||
+	"$output" != *'"binary_name": "foo-nocgo",'*'"cgo_enabled": "0",'* ]]; then
+	echo "FAIL: CGO_ENABLED not recorded: $output" >&2
+	exit 1
+fi
+# Link inputs left in the work directory can’t be relinked
+echo '/\/link /s| \([^ ]*\)$| -extldflags=$WORK/b001/_x001.o \1|' >"$outdir/quoted/quote.sed"
+output=$(CGO_ENABLED=1 PATH="$outdir/quoted:$PATH" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/cgo" ./cmd/cgo 2>&1 || true)
+if [[ "$output" != *"reference the work directory of the build"*"-extldflags="*"/b001/_x001.o"* ]]; then
+	echo "FAIL: unexpected output for link inputs in the work directory: $output" >&2
+	exit 1
+fi
+
+# Intercepting a binary again replaces its link command
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -ldflags "-X main.version=v3" -o foo .
+expect $'Hello unknown!\nVersion "v3"' "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+expect 1 grep -c '^	"-importcfg"$' <("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo)
+
+# A failed interception leaves the database untouched
+expect_failure "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo ./not-a-package
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+
+# Work directories containing spaces are handled
+mkdir "$outdir/work dir"
+GOTMPDIR="$outdir/work dir" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-spaces .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-spaces
+
+# The external linker can be overridden
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --extld /opt/cc --dry-run -- foo)
+if [[ "$output" != *'"-extld=/opt/cc"'* || "$output" == *'"-extld='[!/]* ]]; then
+	echo "FAIL: unexpected external linker in dry run output: $output" >&2
+	exit 1
+fi
+
+# A linker from another Go version is reported, and refused with -strict
+cat >"$outdir/link" <<'EOF'
+#!/bin/sh
+echo "link version go1.0"
+EOF
+chmod +x "$outdir/link"
+output=$("$ROOT_DIR/bin/executor" --log-level 1 --db "$dbpath" --link "$outdir/link" --dry-run -- foo 2>&1)
+if [[ "$output" != *"level=WARN"*"link command captured with $(go env GOVERSION) but the linker is go1.0"* ]]; then
+	echo "FAIL: unexpected version mismatch output: $output" >&2
+	exit 1
+fi
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/link" --strict --dry-run -- foo
+
+# A missing or non-executable linker is refused before relinking
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/no-such-link" -- foo 2>&1 || true)
+if [[ "$output" != *"unable to use linker \"$outdir/no-such-link\": "*"no such file or directory"* ]]; then
+	echo "FAIL: unexpected output with a missing linker: $output" >&2
+	exit 1
+fi
+chmod -x "$outdir/link"
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/link" -- foo 2>&1 || true)
+if [[ "$output" != *"unable to use linker \"$outdir/link\": not executable"* ]]; then
+	echo "FAIL: unexpected output with a non-executable linker: $output" >&2
+	exit 1
+fi
+chmod +x "$outdir/link"
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir" -- foo
+
+# A replay in another module mode is reported
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -mod=vendor -o foo-vendor .
+output=$("$ROOT_DIR/bin/executor" --log-level 1 --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-vendor 2>&1 >/dev/null)
+if [[ "$output" != *"level=WARN"*"link command captured with -mod=vendor but replayed with -mod="* ]]; then
+	echo "FAIL: unexpected module mode mismatch output: $output" >&2
+	exit 1
+fi
+# The executor is a transparent linker shim, only reporting warnings by default
+output=$("$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-vendor 2>&1 >/dev/null)
+if [[ "$output" != *"level=WARN"*"Module mode mismatch"* || "$output" == *"level=INFO"* ]]; then
+	echo "FAIL: unexpected output at log level 0: $output" >&2
+	exit 1
+fi
+output=$(GOFLAGS=-mod=vendor "$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-vendor 2>&1 >/dev/null)
+if [[ -n "$output" ]]; then
+	echo "FAIL: unexpected module mode mismatch output: $output" >&2
+	exit 1
+fi
+GOFLAGS=-mod=mod "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-vendor .
+output=$(GOFLAGS=-mod=mod "$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-vendor 2>&1 >/dev/null)
+if [[ -n "$output" ]]; then
+	echo "FAIL: unexpected module mode mismatch output: $output" >&2
+	exit 1
+fi
+# The module mode set by `go env -w` is the default one of the replay too
+GOENV="$outdir/goenv" GOFLAGS= go env -w GOFLAGS=-mod=vendor 2>/dev/null
+GOENV="$outdir/goenv" GOFLAGS= "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-goenv .
+output=$(GOENV="$outdir/goenv" GOFLAGS= "$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-goenv 2>&1 >/dev/null)
+if [[ -n "$output" ]]; then
+	echo "FAIL: unexpected module mode mismatch output with go env -w: $output" >&2
+	exit 1
+fi
+# A link command captured without -mod is replayed in any module mode
+GOFLAGS= "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-goenv .
+output=$(GOFLAGS=-mod=mod "$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --dry-run -- foo-goenv 2>&1 >/dev/null)
+if [[ -n "$output" ]]; then
+	echo "FAIL: unexpected module mode mismatch output without -mod: $output" >&2
+	exit 1
+fi
+
+# Link commands are stored per target platform
+other_goarch=arm64
+if [[ "$(go env GOARCH)" == "$other_goarch" ]]; then
+	other_goarch=amd64
+fi
+GOARCH="$other_goarch" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo .
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --goarch "$other_goarch" --dry-run -- foo >/dev/null
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+
+# Several binaries built at once are stored under their own name
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir" . ./cmd/bye
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/tests"
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/bye"
+
+# Binaries can be found by a regular expression matching a single stored name
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/match.db" -- go build -o "$outdir/match/" . ./cmd/bye
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/match.db" --match 'y.$' -- ignored args
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/match.db" --match 'match/' 2>&1 || true)
+if [[ "$output" != *"2 binaries match \"match/\""*"$outdir/match/bye"*"$outdir/match/tests"* ]]; then
+	echo "FAIL: ambiguous match not reported: $output" >&2
+	exit 1
+fi
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/match.db" --match nothing
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/match.db" --match 'bye' --tags A
+
+# A binary built into a directory is stored under its name in that directory
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/single/" .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o="$outdir/single" ./cmd/bye
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/single/tests"
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/single/bye"
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/single"
+
+# Installed binaries are stored under their install path
+GOBIN="$outdir/bin" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go install . ./cmd/bye
+GOBIN="$outdir/bin" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go install -tags A .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/bin/tests"
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags A -- "$outdir/bin/tests"
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/bin/bye"
+
+# The combined binary dispatches to the interceptor and the executor
+"$ROOT_DIR/bin/golinkinterceptor" --log-level "$LOG_LEVEL" --db "$dbpath" intercept -- go build -tags A -o foo-combined .
+expect "Hello A!" "$ROOT_DIR/bin/golinkinterceptor" --log-level "$LOG_LEVEL" --db "$dbpath" exec --link "$(go env GOTOOLDIR)/link" --tags A -- foo-combined
+output=$("$ROOT_DIR/bin/golinkinterceptor" -h 2>&1)
+if [[ "$output" != *"Commands:"*"intercept"*"exec"*"-db"*"-log-level"* ]]; then
+	echo "FAIL: unexpected help output: $output" >&2
+	exit 1
+fi
+expect_status 2 "$ROOT_DIR/bin/golinkinterceptor" --db "$dbpath"
+expect_status 2 "$ROOT_DIR/bin/golinkinterceptor" --db "$dbpath" unknown
+expect_status 2 "$ROOT_DIR/bin/golinkinterceptor" --db "$dbpath" exec
+
+# Concurrent interceptions of the same database all succeed
+pids=()
+for i in 1 2 3 4; do
+	"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/concurrent$i" . &
+	pids+=($!)
+done
+for pid in "${pids[@]}"; do
+	wait "$pid"
+done
+for i in 1 2 3 4; do
+	expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/concurrent$i"
+done
+
+# Build modes are recorded and checked
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -buildmode=pie -o foo-pie .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -buildmode=c-shared -o "$outdir/foo.so" .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --buildmode pie -- foo-pie
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --buildmode exe -- foo-pie
+expect "Relinked binary is identical to a fresh build of ." "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --verify . -- foo-pie
+# C shared libraries and plugins are written to a file but not executed
+mkdir "$outdir/libs"
+output=$(TMPDIR="$outdir/libs" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/foo.so" 2>&1)
+if [[ "$output" != *"-buildmode=c-shared and can’t be executed, written to "*"/foo.so" ]]; then
+	echo "FAIL: unexpected output when executing a C shared library: $output" >&2
+	exit 1
+fi
+[[ -s "${output##* written to }" ]]
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -buildmode=plugin -o "$outdir/plugin.so" .
+output=$(TMPDIR="$outdir/libs" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- "$outdir/plugin.so" 2>&1)
+if [[ "$output" != *"-buildmode=plugin and can’t be executed, written to "*"/plugin.so" ]]; then
+	echo "FAIL: unexpected output when executing a plugin: $output" >&2
+	exit 1
+fi
+[[ -s "${output##* written to }" ]]
+output=$(TMPDIR="$outdir/libs" "$ROOT_DIR/bin/executor" --log-level 1 --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --keep-temp -- "$outdir/plugin.so" 2>&1)
+if [[ "$output" != *"Binary kept at "*"/plugin.so"* || "$output" == *"msg=Exec"* || "$output" == *"msg=Spawn"* ]]; then
+	echo "FAIL: unexpected output when keeping a plugin: $output" >&2
+	exit 1
+fi
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -o "$outdir/relinked.so" -- "$outdir/foo.so"
+[[ -s "$outdir/relinked.so" ]]
+expect "Relinked binary is identical to a fresh build of ." "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --verify . -- "$outdir/foo.so"
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --list)
+if [[ "$output" != *"foo-pie "*" pie "* || "$output" != *"foo.so "*" c-shared "* ]]; then
+	echo "FAIL: build modes missing from list output: $output" >&2
+	exit 1
+fi
+
+# List the stored binaries
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --list)
+if [[ "$output" != *"foo-ldflags "*'"-ldflags=-X '* ]]; then
+	echo "FAIL: unexpected list output: $output" >&2
+	exit 1
+fi
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --list --json)
+if [[ "$output" != *'"binary_name": "foo-ldflags"'* ]]; then
+	echo "FAIL: unexpected JSON list output: $output" >&2
+	exit 1
+fi
+
+# A missing binary is reported as JSON along with its other variants
+expect_status 4 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --json --tags C -- foo
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --json --tags C -- foo || true)
+if [[ "$output" != *'"error": "no link command found for \"foo\" with build tags [\"C\"]'* ||
+	"$output" != *'"binary_name": "foo"'* ||
+	"$output" != *'"alternatives": ['*'"A"'*'"B"'*'"build_tags": []'* ]]; then
+	echo "FAIL: unexpected JSON error output: $output" >&2
+	exit 1
+fi
+
+# Race-instrumented builds are stored alongside the regular ones
+racedb="$outdir/race.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$racedb" -- go build -o foo .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$racedb" -- go build -race -o foo .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$racedb" -- foo
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$racedb" --race -- foo
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$racedb" -o "$outdir/foo-norace" -- foo
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$racedb" --race -o "$outdir/foo-race" -- foo
+expect "" sh -c 'go version -m "$1" | grep -e "-race=true" || true' sh "$outdir/foo-norace"
+expect $'\tbuild\t-race=true' sh -c 'go version -m "$1" | grep -e "-race=true"' sh "$outdir/foo-race"
+expect_status 4 "$ROOT_DIR/bin/executor" --db "$racedb" --json --msan -- foo
+
+# A missing database is told apart from a corrupt one
+output=$("$ROOT_DIR/bin/executor" --db "$outdir/missing.db" --list 2>&1 || true)
+if [[ "$output" != *"database \"$outdir/missing.db\" not found; run the interceptor first"* || -e "$outdir/missing.db" ]]; then
+	echo "FAIL: unexpected missing database error: $output" >&2
+	exit 1
+fi
+head -c 4096 /dev/urandom >"$outdir/corrupt.db"
+output=$("$ROOT_DIR/bin/executor" --db "$outdir/corrupt.db" -- foo 2>&1 || true)
+if [[ "$output" != *"database \"$outdir/corrupt.db\" is corrupt"* ]]; then
+	echo "FAIL: unexpected corrupt database error: $output" >&2
+	exit 1
+fi
+
+# Statistics count the package files shared by the link commands
+statsdb="$outdir/stats.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$statsdb" -- go build -o "$outdir/stats/" . ./cmd/bye
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$statsdb" --dump-importcfg "$outdir/stats/foo.importcfg" -- "$outdir/stats/tests"
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$statsdb" --dump-importcfg "$outdir/stats/bye.importcfg" -- "$outdir/stats/bye"
+references=$(cat "$outdir"/stats/*.importcfg | grep -c '^packagefile ')
+package_files=$(cat "$outdir"/stats/*.importcfg | grep '^packagefile ' | sort -u | wc -l)
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$statsdb" --stats --json)
+if [[ "$output" != *'"link_commands": 2,'*"\"package_files\": $package_files,"*"\"references\": $references,"* ]]; then
+	echo "FAIL: unexpected statistics: $output" >&2
+	exit 1
+fi
+expect "Link commands per package file:  $(awk "BEGIN { printf \"%.2f\", $references / $package_files }")" \
+	sh -c '"$1" --db "$2" --stats | grep "^Link commands per package file:"' sh "$ROOT_DIR/bin/executor" "$statsdb"
+
+# Only the link commands matched by -only are stored
+onlydb="$outdir/only.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$onlydb" --only '.*/cmd/bye' -- go build -o "$outdir/only/" . ./cmd/bye
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$onlydb" --only 'b?e' -- go build -o "$outdir/only/glob/" . ./cmd/bye
+expect "$outdir/only/bye"$'\n'"$outdir/only/glob/bye" \
+	sh -c '"$1" --db "$2" --list --json | sed -n "s/.*\"binary_name\": \"\(.*\)\".*/\1/p"' sh "$ROOT_DIR/bin/executor" "$onlydb"
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$onlydb" --link "$(go env GOTOOLDIR)/link" -- "$outdir/only/bye"
+expect_failure "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$onlydb" --only nothing -- go build -o "$outdir/only/" . ./cmd/bye
+
+# Exporting and importing the database is lossless
+importdb="$outdir/import.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --export "$outdir/export.json"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$importdb" --import "$outdir/export.json"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$importdb" --export "$outdir/reexport.json"
+expect "" diff "$outdir/export.json" "$outdir/reexport.json"
+expect "$("$ROOT_DIR/bin/executor" --db "$dbpath" --list --json)" "$ROOT_DIR/bin/executor" --db "$importdb" --list --json
+mkdir "$outdir/dry-run-tmp"
+dry_run() {
+	TMPDIR="$outdir/dry-run-tmp" "$ROOT_DIR/bin/executor" --db "$@" | sed "s#$outdir/dry-run-tmp/\([a-z.-]*\)[0-9]*#\1#g"
+}
+for args in "-- foo" "--tags A -- foo" "-- foo-ldflags" "-- $outdir/bye"; do
+	expect "$(dry_run "$dbpath" --dry-run $args)" dry_run "$importdb" --dry-run $args
+done
+expect_failure "$ROOT_DIR/bin/interceptor" --db "$importdb" --import "$outdir/export.json"
+
+# An in-memory database is exported after the build is stored in it
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db :memory: --export "$outdir/memory.json" -- go build -o foo-memory .
+[[ ! -e :memory: ]] || { echo "FAIL: in-memory database written to disk" >&2; exit 1; }
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/memory.db" --import "$outdir/memory.json"
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/memory.db" --link "$(go env GOTOOLDIR)/link" -- foo-memory
+expect_failure "$ROOT_DIR/bin/executor" --db :memory: -- foo-memory
+
+# The Go environment of the build is restored for the linker on demand
+GOFLAGS="-mod=mod -p=4" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-restore-env .
+printf '#!/bin/sh\nenv >"%s"\nexec "%s" "$@"\n' "$outdir/link-env" "$(go env GOTOOLDIR)/link" >"$outdir/env-link"
+chmod +x "$outdir/env-link"
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/env-link" --mod mod -o "$outdir/not-restored" -- foo-restore-env
+if grep -q '^GOFLAGS=-mod=mod -p=4$' "$outdir/link-env"; then
+	echo "FAIL: build environment restored without -restore-env" >&2
+	exit 1
+fi
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/env-link" --mod mod --restore-env -o "$outdir/restored" -- foo-restore-env
+grep -q '^GOFLAGS=-mod=mod -p=4$' "$outdir/link-env"
+grep -q "^GOAMD64=$(go env GOAMD64)$" "$outdir/link-env" || [[ -z "$(go env GOAMD64)" ]]
+expect "Hello unknown!" "$outdir/restored"
+# The environment of link commands exported before it was recorded is unknown
+sed '/"env": {/,/},/d' "$outdir/memory.json" >"$outdir/no-env.json"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/no-env.db" --import "$outdir/no-env.json"
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/no-env.db" -o "$outdir/restored" -- foo-memory
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/no-env.db" --restore-env -o "$outdir/restored" -- foo-memory 2>&1 || true)
+if [[ "$output" != *"build environment of the link command is unknown"* ]]; then
+	echo "FAIL: unexpected output for an unknown build environment: $output" >&2
+	exit 1
+fi
+
+# Validating a database reports the incomplete link commands
+expect "" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --validate
+expect "" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/memory.db" --validate
+if command -v sqlite3 >/dev/null; then
+	cp "$outdir/memory.db" "$outdir/broken.db"
+	sqlite3 "$outdir/broken.db" "DELETE FROM package_file WHERE package_file_id = (SELECT max(package_file_id) FROM link_command_package_file); UPDATE link_command SET args = jsonb('[]'), main_package_id = NULL;"
+	output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/broken.db" --validate 2>&1 || true)
+	if [[ "$output" != *"of foo-memory has no main package"*"references the missing package file "*"of foo-memory has no arguments"*"3 problems found in database"* ]]; then
+		echo "FAIL: unexpected validation of a broken database: $output" >&2
+		exit 1
+	fi
+	expect_status 1 "$ROOT_DIR/bin/executor" --db "$outdir/broken.db" --validate
+fi
+
+# The importcfg lines shared by link commands, like the modinfo of binaries of
+# the same package, are stored once
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/share.db" -- go build -o foo-share-a .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/share.db" -- go build -o foo-share-b .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/share.db" -- foo-share-b
+if command -v sqlite3 >/dev/null; then
+	expect "1|2" sqlite3 "$outdir/share.db" "SELECT count(DISTINCT importcfg_line_id), count(DISTINCT link_command_id) FROM importcfg_additional_lines NATURAL JOIN importcfg_line WHERE line LIKE 'modinfo %';"
+	expect "$(sqlite3 "$outdir/share.db" "SELECT count(*) FROM importcfg_additional_lines WHERE link_command_id = 1;")" sqlite3 "$outdir/share.db" "SELECT count(*) FROM importcfg_line;"
+fi
+
+# A link command referencing the removed work directory isn’t relinked
+workdir=$(sed -n 's/.*"work_dir": "\(.*\)",$/\1/p' "$outdir/memory.json")
+if [[ -z "$workdir" ]]; then
+	echo "FAIL: work directory not recorded: $(cat "$outdir/memory.json")" >&2
+	exit 1
+fi
+sed "s|\"args\": \[|&\"-extldflags=$workdir/b001/_x001.o\",|" "$outdir/memory.json" >"$outdir/stray.json"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/stray.db" --import "$outdir/stray.json"
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/stray.db" --link "$(go env GOTOOLDIR)/link" -- foo-memory 2>&1 || true)
+if [[ "$output" != *"reference the work directory $workdir of the intercepted build"*"-extldflags=$workdir/b001/_x001.o"* ]]; then
+	echo "FAIL: unexpected output for a stray work directory path: $output" >&2
+	exit 1
+fi
+
+# The importcfg is checked against the hash of the one of the intercepted build
+expect "Importcfg matches the intercepted build" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/memory.db" --check -- foo-memory
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/memory.db" --check --replace "fmt=$(go list -export -f '{{.Export}}' errors)" -- foo-memory
+sed "/\"package\": \"fmt\",/{n;s|\"file\": \".*\"|\"file\": \"$(go list -export -f '{{.Export}}' errors)\"|;}" "$outdir/memory.json" >"$outdir/drift.json"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/drift.db" --import "$outdir/drift.json"
+output=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/drift.db" --check -- foo-memory 2>&1 || true)
+if [[ "$output" != *"importcfg differs from the one of the intercepted build"* ]]; then
+	echo "FAIL: importcfg drift not detected: $output" >&2
+	exit 1
+fi
+
+# Databases are compared
+diffa="$outdir/diff-a.db"
+diffb="$outdir/diff-b.db"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$diffa" -- go build -ldflags "-X main.version=v1" -o foo-diff .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$diffa" -- go build -o foo-diff-removed .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$diffb" --relative-cache -- go build -ldflags "-X main.version=v2" -o foo-diff .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$diffb" -- go build -o foo-diff-added .
+expect "" "$ROOT_DIR/bin/executor" --db "$diffa" --diff "$diffa"
+platform="$(go env GOOS)/$(go env GOARCH)"
+output=$("$ROOT_DIR/bin/executor" --db "$diffa" --diff "$diffb")
+if [[ "$output" != "~ foo-diff $platform"$'\n'*$'\t-arg "main.version=v1"\n'*$'\t+arg "main.version=v2"\n'*$'\t~package fmt: '"$(go env GOCACHE)/"*' -> $GOCACHE/'*$'\n+ foo-diff-added '"$platform"$'\n- foo-diff-removed '"$platform" ]]; then
+	echo "FAIL: unexpected differences between databases: $output" >&2
+	exit 1
+fi
+expect_failure "$ROOT_DIR/bin/executor" --db "$diffa" --diff "$outdir/no-such.db"
+
+# Prune link commands from the database
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --prune foo --prune-tags A
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --prune foo-ldflags
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --prune-orphans
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags A -- foo
+expect_failure "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-ldflags
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
+expect "Hello B!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags B -- foo
+
+# Object files removed from the Go build cache are reported before linking
+gocache=$(mktemp -d --tmpdir golinkinterceptor.gocache.XXXXXXXXXX)
+GOCACHE="$gocache" "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-trimmed .
+rm -rf "$gocache"
+expect_status 3 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo-trimmed
+
+# Build tags are normalized the same way by both tools
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags "" -o foo-no-tags .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-no-tags
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --tags , -- foo-no-tags
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags "X A" -o foo-tags .
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --tags A,X,A -- foo-tags
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" -- foo-tags
+
+# The build tags of GOFLAGS are recorded unless overridden on the command line
+GOFLAGS=-tags=X,A "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-goflags .
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --tags A,X -- foo-goflags
+expect "Hello A!" env GOFLAGS=-tags=A,X "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- foo-goflags
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" -- foo-goflags
+GOFLAGS=-tags=A "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags B -o foo-goflags-override .
+expect "Hello B!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --tags B -- foo-goflags-override
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" --tags A,B -- foo-goflags-override
+
+# Binaries built with a relative name are found from other directories
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o ./foo-cwd .
+expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- ./foo-cwd
+expect "Hello unknown!" sh -c 'cd "$1" && shift && "$@"' sh "$outdir" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- "$srcdir/foo-cwd"
+expect "Hello unknown!" sh -c 'cd "$1" && shift && "$@"' sh "$srcdir/cmd" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- ../foo-cwd
+output=$("$ROOT_DIR/bin/interceptor" --db "$dbpath" --export /dev/stdout)
+if [[ "$output" != *'"binary_name": "foo-cwd",'*'"build_dir": "'"$srcdir"'",'* ]]; then
+	echo "FAIL: relative binary name or working directory not recorded: $output" >&2
+	exit 1
+fi
+
+# Multi-call binaries can be executed under another name
+mkdir "$outdir/multicall"
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o "$outdir/multicall/bye" ./cmd/multicall
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" -- "$outdir/multicall/bye"
+expect "Hi!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --argv0 /usr/bin/hi -- "$outdir/multicall/bye"
+expect_status 1 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --argv0 ls -- "$outdir/multicall/bye"
+
+# A spawned binary forwards its exit status and is removed once it exits
+expect "Bye!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --spawn -- "$outdir/multicall/bye"
+mkdir "$outdir/spawn-tmp"
+TMPDIR="$outdir/spawn-tmp" expect_status 42 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --spawn --argv0 fail -- "$outdir/multicall/bye"
+if [[ -n "$(ls -A "$outdir/spawn-tmp")" ]]; then
+	echo "FAIL: temporary files left after spawning: $(ls -A "$outdir/spawn-tmp")" >&2
+	exit 1
+fi
+output=$("$ROOT_DIR/bin/executor" --log-level 1 --db "$dbpath" --spawn -- "$outdir/multicall/bye" 2>&1 >/dev/null)
+if [[ "$output" != *"msg=\"Binary exited\" exit_code=0 duration="* ]]; then
+	echo "FAIL: unexpected logs of a spawned binary: $output" >&2
+	exit 1
+fi
+
+# Keep the linked binary instead of executing it
+"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -o "$outdir/kept" -- foo
+expect "Hello unknown!" "$outdir/kept"
+
+# Nothing is printed at the default log level
+output=$("$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --replace "fmt=$(go list -export -f '{{.Export}}' fmt)" -o "$outdir/quiet" -- foo 2>&1)
+if [[ -n "$output" ]]; then
+	echo "FAIL: unexpected output at log level 0: $output" >&2
+	exit 1
+fi
+
+# Logs can be structured as JSON
+output=$("$ROOT_DIR/bin/executor" --log-level 1 --log-format json --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -o "$outdir/json-logs" -- foo 2>&1)
+if [[ "$output" != *'"level":"INFO","msg":"Binary written","path":"'"$outdir/json-logs"'"'* ]]; then
+	echo "FAIL: unexpected JSON logs: $output" >&2
+	exit 1
+fi
+output=$("$ROOT_DIR/bin/interceptor" --log-level 2 --log-format json --db "$dbpath" -- go build -o foo . 2>&1)
+if [[ "$output" != *'"level":"DEBUG"'*'"file":'* ]]; then
+	echo "FAIL: unexpected JSON debug logs: $output" >&2
+	exit 1
+fi
+expect_status 2 "$ROOT_DIR/bin/executor" --log-format xml --db "$dbpath" -- foo
+
+# The importcfg and the binary can be kept for inspection
+mkdir "$outdir/keep"
+output=$(TMPDIR="$outdir/keep" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --keep-temp -- foo 2>&1)
+if [[ "$output" != *"Importcfg kept at $outdir/keep/"*"Binary kept at $outdir/keep/"*"Hello unknown!"* ]]; then
+	echo "FAIL: unexpected output when keeping the temporary files: $output" >&2
+	exit 1
+fi
+grep -q '^packagefile fmt=' "$outdir"/keep/golinkinterceptor-*/importcfg.link
+expect "Hello unknown!" "$outdir"/keep/golinkinterceptor-*/foo
+
+# The warnings of a successful link are only logged in debug mode
+printf '#!/bin/sh\necho "link: warning" >&2\nexec "%s" "$@"\n' "$(go env GOTOOLDIR)/link" >"$outdir/warning-link"
+chmod +x "$outdir/warning-link"
+output=$("$ROOT_DIR/bin/executor" --db "$dbpath" --link "$outdir/warning-link" -o "$outdir/warned" -- foo 2>&1)
+if [[ "$output" == *"link: warning"* ]]; then
+	echo "FAIL: linker warning reported without debug logs: $output" >&2
+	exit 1
+fi
+output=$("$ROOT_DIR/bin/executor" --log-level 2 --db "$dbpath" --link "$outdir/warning-link" -o "$outdir/warned" -- foo 2>&1)
+if [[ "$output" != *'level=DEBUG msg="Linker output" stderr="link: warning\n"'* ]]; then
+	echo "FAIL: linker warning not logged: $output" >&2
+	exit 1
+fi
+
+# Interrupting a hung linker removes the temporary files
+tmpdir="$outdir/tmp"
+mkdir "$tmpdir"
+printf '#!/bin/sh\nexec sleep 60\n' >"$outdir/hung-link"
+chmod +x "$outdir/hung-link"
+TMPDIR="$tmpdir" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/hung-link" -- foo 2>/dev/null &
+pid=$!
+sleep 1
+kill -INT "$pid"
+expect_failure wait "$pid"
+if [[ -n "$(ls -A "$tmpdir")" ]]; then
+	echo "FAIL: temporary files left after interruption: $(ls -A "$tmpdir")" >&2
+	exit 1
+fi
+
+# Failures exit with a meaningful status and remove the temporary files
+printf '#!/bin/sh\necho "link: failed" >&2\nexit 42\n' >"$outdir/failing-link"
+chmod +x "$outdir/failing-link"
+expect_status 2 "$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build .
+expect_status 2 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link"
+expect_status 1 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- not-intercepted
+TMPDIR="$tmpdir" expect_status 1 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --replace "not/a/package=$(go list -export -f '{{.Export}}' fmt)" -- foo
+TMPDIR="$tmpdir" expect_status 42 "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/failing-link" -- foo
+output=$("$ROOT_DIR/bin/executor" --db "$dbpath" --link "$outdir/failing-link" -- foo 2>&1 || true)
+if [[ "$output" != *"link: failed"* ]]; then
+	echo "FAIL: linker error not reported: $output" >&2
+	exit 1
+fi
+if [[ -n "$(ls -A "$tmpdir")" ]]; then
+	echo "FAIL: temporary files left after failures: $(ls -A "$tmpdir")" >&2
+	exit 1
+fi
+
+# -metrics reports the duration of each phase on stderr
+check_metrics() {
+	local phases=$1 metrics=$2
+	expect "$phases" awk 'NR > 1 && $1 != "total" { NF--; print }' <<<"$metrics"
+	if ! awk 'NR > 1 && $NF < 0 { exit 1 } NR > 1 && $1 != "total" { sum += $NF } $1 == "total" && sum > $NF + 0.001 { exit 1 }' <<<"$metrics"; then
+		echo "FAIL: inconsistent metrics: $metrics" >&2
+		exit 1
+	fi
+}
+metrics=$("$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" --metrics -- go build -o foo-metrics . 2>&1 >/dev/null)
+check_metrics $'build\ncache check\nstore' "$metrics"
+metrics=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --metrics --link "$(go env GOTOOLDIR)/link" -- foo-metrics 2>&1 >/dev/null)
+check_metrics $'query\nimportcfg\nlink' "$metrics"
+metrics=$("$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --metrics --spawn --link "$(go env GOTOOLDIR)/link" -- foo-metrics 2>&1 >/dev/null)
+check_metrics $'query\nimportcfg\nlink\nrun' "$metrics"
+
+# -codesign signs the binary before running it, ad-hoc unless an identity is given
+mkdir "$outdir/codesign"
+printf '#!/bin/sh\necho "$@" >>"%s"\n' "$outdir/codesign/calls" >"$outdir/codesign/codesign"
+chmod +x "$outdir/codesign/codesign"
+PATH="$outdir/codesign:$PATH" expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --codesign --link "$(go env GOTOOLDIR)/link" -- foo
+PATH="$outdir/codesign:$PATH" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --codesign --codesign-identity "Developer ID" --link "$(go env GOTOOLDIR)/link" -o "$outdir/foo-signed" -- foo
+PATH="$outdir/codesign:$PATH" expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --codesign=false --link "$(go env GOTOOLDIR)/link" -- foo
+expect 2 wc -l <"$outdir/codesign/calls"
+expect "--force --sign - " sed -n '1s#[^ ]*$##p' "$outdir/codesign/calls"
+expect "--force --sign Developer ID $outdir/foo-signed" sed -n 2p "$outdir/codesign/calls"
+printf '#!/bin/sh\necho "codesign: no identity found" >&2\nexit 1\n' >"$outdir/codesign/codesign"
+output=$(PATH="$outdir/codesign:$PATH" "$ROOT_DIR/bin/executor" --db "$dbpath" --codesign --link "$(go env GOTOOLDIR)/link" -- foo 2>&1 || true)
+if [[ "$output" != *"unable to sign binary"*"codesign: no identity found"* ]]; then
+	echo "FAIL: signing failure not reported: $output" >&2
+	exit 1
+fi
+
+# Equivalent build tags, like no tags as null or as an empty array, or tags
+# unsorted or duplicated, are stored as one row and looked up the same way
+if command -v jq >/dev/null && command -v sqlite3 >/dev/null; then
+	jq '.link_commands[0] as $c | .link_commands = [
+		$c + {binary_name: "foo-tags-nil", build_tags: null},
+		$c + {binary_name: "foo-tags-empty", build_tags: []},
+		$c + {binary_name: "foo-tags-dup", build_tags: ["A", "B", "A"]},
+		$c + {binary_name: "foo-tags-unsorted", build_tags: ["B", "A"]}
+	]' "$outdir/memory.json" >"$outdir/tags.json"
+	"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$outdir/tags.db" --import "$outdir/tags.json"
+	expect $'|null\nA,B|["A","B"]' sqlite3 "$outdir/tags.db" "SELECT canonical, json(tags) FROM build_tags ORDER BY canonical;"
+	expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/tags.db" -- foo-tags-nil
+	expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/tags.db" -- foo-tags-empty
+	expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/tags.db" --tags B,A -- foo-tags-dup
+	expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$outdir/tags.db" --tags A,A,B -- foo-tags-unsorted
+fi
+
+# -select-latest selects the variant of a binary stored last, any build tags,
+# platform or instrumentation matching unless given by their flags
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags A -o foo-latest .
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags B -o foo-latest .
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" -- foo-latest
+expect "Hello B!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --select-latest -- foo-latest
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --select-latest --tags A -- foo-latest
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" --select-latest --goos plan9 -- foo-latest
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags A -o foo-latest .
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --select-latest -- foo-latest
+# Among the binaries matched by -match too
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -tags B -o foo-latest-other .
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" --tags B --match '^foo-latest'
+expect "Hello B!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --select-latest --match '^foo-latest'
+expect "Hello A!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --select-latest --tags A --match '^foo-latest'
+
+# The time link commands are first and last stored at is listed, and only the
+# latter changes when they are intercepted again
+stored_at() {
+	"$ROOT_DIR/bin/executor" --db "$dbpath" --list --json | sed -n '/"binary_name": "foo-stamped"/,/}/s/.*"'"$1"'": "\(.*\)".*/\1/p'
+}
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-stamped .
+created=$(stored_at created_at)
+updated=$(stored_at updated_at)
+if [[ ! "$created" =~ ^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+Z$ || "$updated" != "$created" ]]; then
+	echo "FAIL: unexpected times of a new link command: $created $updated" >&2
+	exit 1
+fi
+"$ROOT_DIR/bin/interceptor" --log-level "$LOG_LEVEL" --db "$dbpath" -- go build -o foo-stamped .
+expect "$created" stored_at created_at
+if [[ ! "$(stored_at updated_at)" > "$updated" ]]; then
+	echo "FAIL: update time not changed by a new interception: $updated $(stored_at updated_at)" >&2
+	exit 1
+fi
+output=$("$ROOT_DIR/bin/executor" --db "$dbpath" --list)
+if [[ "$output" != *"CREATED"*"UPDATED"* || "$output" != *"foo-stamped "*"$created "* ]]; then
+	echo "FAIL: times missing from list output: $output" >&2
+	exit 1
+fi
 
-"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" -- foo
-"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags A -- foo
-"$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tags B -- foo
+# The importcfg and the binary are created in the directory given by -tmpdir,
+# or by TMPDIR, and removed once linked
+mkdir "$outdir/exec-tmp"
+printf '#!/bin/sh\necho "$@" >"%s"\nexec "%s" "$@"\n' "$outdir/tmpdir-link-args" "$(go env GOTOOLDIR)/link" >"$outdir/tmpdir-link"
+chmod +x "$outdir/tmpdir-link"
+check_temp_files() {
+	if [[ "$(cat "$outdir/tmpdir-link-args")" != *"-o $outdir/exec-tmp/foo"*"-importcfg $outdir/exec-tmp/importcfg.link"* ]]; then
+		echo "FAIL: temporary files not created in $outdir/exec-tmp: $(cat "$outdir/tmpdir-link-args")" >&2
+		exit 1
+	fi
+	if [[ -n "$(ls -A "$outdir/exec-tmp")" || -n "$(ls -A "$tmpdir")" ]]; then
+		echo "FAIL: temporary files left: $(ls -A "$outdir/exec-tmp" "$tmpdir")" >&2
+		exit 1
+	fi
+}
+TMPDIR="$tmpdir" expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/tmpdir-link" --tmpdir "$outdir/exec-tmp" --spawn -- foo
+check_temp_files
+TMPDIR="$outdir/exec-tmp" expect "Hello unknown!" "$ROOT_DIR/bin/executor" --log-level "$LOG_LEVEL" --db "$dbpath" --link "$outdir/tmpdir-link" --spawn -- foo
+check_temp_files
+expect_failure "$ROOT_DIR/bin/executor" --db "$dbpath" --link "$(go env GOTOOLDIR)/link" --tmpdir "$outdir/missing-tmp" -- foo
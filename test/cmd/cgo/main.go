@@ -0,0 +1,15 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package main
+
+// static int add(int a, int b) { return a + b; }
+import "C"
+
+import "fmt"
+
+func main() {
+	fmt.Println("Cgo says", C.add(1, 2))
+}
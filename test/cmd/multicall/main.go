@@ -0,0 +1,28 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// multicall behaves like the command it is invoked as.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	switch name := filepath.Base(os.Args[0]); name {
+	case "hi":
+		fmt.Println("Hi!")
+	case "bye":
+		fmt.Println("Bye!")
+	case "fail":
+		fmt.Println("Failing!")
+		os.Exit(42)
+	default:
+		fmt.Printf("Unknown command %s\n", name)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,11 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+// Package greet is built as a test binary by `go test -c`.
+package greet
+
+func Greeting(name string) string {
+	return "Hello " + name + "!"
+}
@@ -0,0 +1,18 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2025-present Datadog, Inc.
+
+package greet
+
+import "testing"
+
+func TestGreeting(t *testing.T) {
+	if got := Greeting("test"); got != "Hello test!" {
+		t.Errorf("Greeting() = %q", got)
+	}
+}
+
+func TestFailing(t *testing.T) {
+	t.Error("failing on purpose")
+}
@@ -5,6 +5,13 @@
 
 package main
 
+import "fmt"
+
+var version string
+
 func main() {
 	hello()
+	if version != "" {
+		fmt.Printf("Version %q\n", version)
+	}
 }